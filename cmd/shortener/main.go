@@ -1,31 +1,85 @@
 package main
 
 import (
-	"context"
+	"errors"
+	"flag"
 	"log"
+	"os"
 	"shortener/config"
+	"shortener/internal/account"
+	"shortener/internal/analytics"
+	"shortener/internal/auth"
+	"shortener/internal/deletion"
 	"shortener/internal/middleware/logger"
 	"shortener/internal/server"
+	"shortener/internal/short"
 	"shortener/internal/storage"
+	"shortener/internal/validate"
 )
 
 func main() {
-	cfg := config.GetConfig()
+	cfg, err := config.Load(os.Args[1:], os.Getenv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
 
-	s, err := storage.NewStorage(cfg)
+	if err := config.EnsureJWTSecret(&cfg); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if err := config.EnsureAnalyticsKey(&cfg); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	keys, err := auth.NewKeyring(cfg)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	lg, err := logger.NewLogger()
+	lg, err := logger.New(cfg.LogLevel, cfg.LogFormat)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	m := server.NewMiddleware(lg, cfg)
-	h := server.NewHandlers(context.Background(), cfg, s, lg)
+	s, err := storage.NewStorage(cfg, lg)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	gen, err := short.NewGenerator(cfg, s)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	policy, err := validate.NewPolicy(cfg)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	recorder := analytics.NewRecorder(s, []byte(cfg.AnalyticsKey), lg)
+	deleter := deletion.NewWorker(s, lg)
+
+	// API keys and accounts are only kept in the database.
+	apiKeys, _ := s.(storage.APIKeyStorage)
+
+	var accounts *account.Service
+	if users, ok := s.(storage.UserStorage); ok {
+		accounts = account.NewService(users)
+	}
+
+	m := server.NewMiddleware(lg, cfg, keys, apiKeys)
+	h := server.NewHandlers(cfg, s, short.NewAllocator(s, gen), policy, recorder, deleter, keys, apiKeys, accounts, lg)
 
 	err = server.Run(h, m)
 	if err != nil {
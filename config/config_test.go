@@ -0,0 +1,251 @@
+package config
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"shortener/internal/logging"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	yamlFile := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte("server_address: file:1\nbase_url: http://file\nshutdown_timeout: 3s\ntls_autocert_domains: [a.example, b.example]\n"), 0600))
+	jsonFile := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(jsonFile, []byte(`{"server_address": "json:1", "short_url_length": 12}`), 0600))
+	unknownFile := filepath.Join(dir, "unknown.yaml")
+	require.NoError(t, os.WriteFile(unknownFile, []byte("server_adress: typo:1\n"), 0600))
+
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		check   func(t *testing.T, cfg Config)
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, defaults(), cfg)
+			},
+		},
+		{
+			name: "yaml file",
+			args: []string{"-c", yamlFile},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "file:1", cfg.ServerAddr)
+				assert.Equal(t, "https://file", cfg.BaseURL)
+				assert.Equal(t, 3*time.Second, cfg.ShutdownTimeout)
+				assert.Equal(t, []string{"a.example", "b.example"}, cfg.TLSAutocertDomains)
+			},
+		},
+		{
+			name: "json file from env",
+			env:  map[string]string{"CONFIG": jsonFile},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "json:1", cfg.ServerAddr)
+				assert.Equal(t, 12, cfg.ShortURLLength)
+			},
+		},
+		{
+			name: "env overrides file",
+			args: []string{"-c", jsonFile},
+			env:  map[string]string{"SERVER_ADDRESS": "env:1"},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "env:1", cfg.ServerAddr)
+				assert.Equal(t, 12, cfg.ShortURLLength)
+			},
+		},
+		{
+			name: "flags override env",
+			args: []string{"-c", jsonFile, "-a", "flag:1"},
+			env:  map[string]string{"SERVER_ADDRESS": "env:1", "SHORT_URL_LENGTH": "10"},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "flag:1", cfg.ServerAddr)
+				assert.Equal(t, 10, cfg.ShortURLLength)
+			},
+		},
+		{
+			name:    "missing file",
+			args:    []string{"-c", filepath.Join(dir, "missing.yaml")},
+			wantErr: true,
+		},
+		{
+			name:    "unknown file key",
+			args:    []string{"-c", unknownFile},
+			wantErr: true,
+		},
+		{
+			name:    "bad env value",
+			env:     map[string]string{"SHUTDOWN_TIMEOUT": "soon"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown flag",
+			args:    []string{"-unknown"},
+			wantErr: true,
+		},
+		{
+			name:    "relative base url",
+			args:    []string{"-b", "/short"},
+			wantErr: true,
+		},
+		{
+			name: "logging",
+			args: []string{"-log-format", "json"},
+			env:  map[string]string{"LOG_LEVEL": "debug"},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "debug", cfg.LogLevel)
+				assert.Equal(t, logging.FormatJSON, cfg.LogFormat)
+			},
+		},
+		{
+			name:    "unknown log level",
+			env:     map[string]string{"LOG_LEVEL": "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown log format",
+			args:    []string{"-log-format", "xml"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown environment mode",
+			env:     map[string]string{"APP_ENV": "staging"},
+			wantErr: true,
+		},
+		{
+			name:    "default jwt secret in production",
+			args:    []string{"-env", "production", "-s", "jwt_secret"},
+			wantErr: true,
+		},
+		{
+			name:    "short jwt secret in production",
+			args:    []string{"-env", "production", "-s", "secret"},
+			wantErr: true,
+		},
+		{
+			name: "jwt keys",
+			args: []string{"-jwt-active-key", "k2"},
+			env:  map[string]string{"JWT_KEYS": "k1:a, k2:b:c"},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, map[string]string{"k1": "a", "k2": "b:c"}, cfg.JWTKeys)
+				assert.Equal(t, "k2", cfg.JWTActiveKey)
+			},
+		},
+		{
+			name:    "short jwt key in production",
+			args:    []string{"-env", "production", "-jwt-keys", "k1:short"},
+			wantErr: true,
+		},
+		{
+			name: "normalization rules",
+			args: []string{"-normalize-sort-query"},
+			env:  map[string]string{"NORMALIZE_IDN": "false", "NORMALIZE_STRIP_TRACKING": "1"},
+			check: func(t *testing.T, cfg Config) {
+				assert.True(t, cfg.NormalizeCase)
+				assert.False(t, cfg.NormalizeIDN)
+				assert.True(t, cfg.NormalizeSortQuery)
+				assert.True(t, cfg.NormalizeStripTracking)
+			},
+		},
+		{
+			name:    "bad normalization rule",
+			env:     map[string]string{"NORMALIZE_PATH": "maybe"},
+			wantErr: true,
+		},
+		{
+			name: "short jwt secret in development",
+			args: []string{"-s", "secret"},
+			check: func(t *testing.T, cfg Config) {
+				assert.Equal(t, "secret", cfg.JWTSecret)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Load(tt.args, func(key string) string { return tt.env[key] })
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestEnsureJWTSecret(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := Config{Env: EnvProduction, JWTSecretFile: filepath.Join(dir, "secrets", "jwt")}
+	require.NoError(t, EnsureJWTSecret(&cfg))
+	assert.Len(t, cfg.JWTSecret, 2*MinJWTSecretLength)
+
+	// The generated secret is reused on the next start.
+	again := Config{Env: EnvProduction, JWTSecretFile: cfg.JWTSecretFile}
+	require.NoError(t, EnsureJWTSecret(&again))
+	assert.Equal(t, cfg.JWTSecret, again.JWTSecret)
+
+	weakFile := filepath.Join(dir, "weak")
+	require.NoError(t, os.WriteFile(weakFile, []byte("jwt_secret\n"), 0600))
+
+	weak := Config{Env: EnvDevelopment, JWTSecretFile: weakFile}
+	require.NoError(t, EnsureJWTSecret(&weak))
+	assert.Equal(t, "jwt_secret", weak.JWTSecret)
+
+	weak = Config{Env: EnvProduction, JWTSecretFile: weakFile}
+	assert.Error(t, EnsureJWTSecret(&weak))
+
+	assert.Error(t, EnsureJWTSecret(&Config{Env: EnvDevelopment}))
+}
+
+func TestEnsureAnalyticsKey(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg := Config{Env: EnvProduction, JWTSecret: "configured", AnalyticsKeyFile: filepath.Join(dir, "analytics")}
+	require.NoError(t, EnsureAnalyticsKey(&cfg))
+	assert.Len(t, cfg.AnalyticsKey, 2*MinJWTSecretLength)
+	assert.Equal(t, "configured", cfg.JWTSecret)
+
+	again := Config{Env: EnvProduction, AnalyticsKeyFile: cfg.AnalyticsKeyFile}
+	require.NoError(t, EnsureAnalyticsKey(&again))
+	assert.Equal(t, cfg.AnalyticsKey, again.AnalyticsKey)
+
+	configured := Config{AnalyticsKey: "key", AnalyticsKeyFile: filepath.Join(dir, "unused")}
+	require.NoError(t, EnsureAnalyticsKey(&configured))
+	assert.Equal(t, "key", configured.AnalyticsKey)
+	assert.NoFileExists(t, configured.AnalyticsKeyFile)
+}
+
+func TestEnsureJWTSecretConcurrentStart(t *testing.T) {
+	dir := t.TempDir()
+	secret := strings.Repeat("s", MinJWTSecretLength)
+
+	// Another instance created the file and writes the secret a moment
+	// later.
+	path := filepath.Join(dir, "jwt")
+	require.NoError(t, os.WriteFile(path, nil, 0600))
+	go func() {
+		time.Sleep(2 * concurrentReadDelay)
+		_ = os.WriteFile(path, []byte(secret+"\n"), 0600)
+	}()
+
+	cfg := Config{Env: EnvProduction, JWTSecretFile: path}
+	require.NoError(t, EnsureJWTSecret(&cfg))
+	assert.Equal(t, secret, cfg.JWTSecret)
+
+	// The other instance won the race to create the file.
+	got, err := generateSecret(path, "jwt secret")
+	require.NoError(t, err)
+	assert.Equal(t, secret, got)
+
+	empty := filepath.Join(dir, "empty")
+	require.NoError(t, os.WriteFile(empty, nil, 0600))
+	assert.Error(t, EnsureJWTSecret(&Config{JWTSecretFile: empty}))
+}
@@ -2,47 +2,451 @@ package config
 
 import (
 	"flag"
-	"os"
+	"fmt"
+	"io"
+	"shortener/internal/logging"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
 )
 
 type Config struct {
-	ServerAddr      string
-	BaseURL         string
-	FileStoragePath string
-	DatabaseDSN     string
-	JWTSecret       string
+	Env string `yaml:"env"`
+
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"`
+
+	ServerAddr      string `yaml:"server_address"`
+	BaseURL         string `yaml:"base_url"`
+	FileStoragePath string `yaml:"file_storage_path"`
+	DatabaseDSN     string `yaml:"database_dsn"`
+	JWTSecret       string `yaml:"jwt_secret"`
+	JWTSecretFile   string `yaml:"jwt_secret_file"`
+
+	// JWTKeys maps key IDs to secrets. Tokens are signed with JWTActiveKey
+	// and verified with any of them; JWTSecret joins them as key "default".
+	JWTKeys      map[string]string `yaml:"jwt_keys"`
+	JWTActiveKey string            `yaml:"jwt_active_key"`
+	AuthTokenTTL time.Duration     `yaml:"auth_token_ttl"`
+
+	// AnalyticsKey is the HMAC key client IPs of visits are hashed with. It
+	// is kept apart from the JWT keys, so rotating those does not change
+	// the hashes.
+	AnalyticsKey     string `yaml:"analytics_key"`
+	AnalyticsKeyFile string `yaml:"analytics_key_file"`
+
+	ShortURLStrategy string `yaml:"short_url_strategy"`
+	ShortURLLength   int    `yaml:"short_url_length"`
+	ShortURLSalt     string `yaml:"short_url_salt"`
+
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Normalization rules applied to urls before they are stored.
+	NormalizeCase          bool `yaml:"normalize_case"`
+	NormalizeDefaultPort   bool `yaml:"normalize_default_port"`
+	NormalizeIDN           bool `yaml:"normalize_idn"`
+	NormalizePath          bool `yaml:"normalize_path"`
+	NormalizeSortQuery     bool `yaml:"normalize_sort_query"`
+	NormalizeStripTracking bool `yaml:"normalize_strip_tracking"`
+
+	AllowedSchemes       []string `yaml:"allowed_schemes"`
+	URLBlocklistFile     string   `yaml:"url_blocklist_file"`
+	BlockPrivateNetworks bool     `yaml:"block_private_networks"`
+
+	// Rate limits in requests per second per client; zero, the default,
+	// disables them. Clients are told apart by the address of the TCP peer,
+	// so behind a reverse proxy all of them share one limit; such setups
+	// should rate limit at the proxy instead.
+	CreateRateLimit   float64 `yaml:"create_rate_limit"`
+	CreateRateBurst   int     `yaml:"create_rate_burst"`
+	RedirectRateLimit float64 `yaml:"redirect_rate_limit"`
+	RedirectRateBurst int     `yaml:"redirect_rate_burst"`
+
+	TLSCertFile         string   `yaml:"tls_cert_file"`
+	TLSKeyFile          string   `yaml:"tls_key_file"`
+	TLSSelfSigned       bool     `yaml:"tls_self_signed"`
+	TLSAutocertDomains  []string `yaml:"tls_autocert_domains"`
+	TLSAutocertCacheDir string   `yaml:"tls_autocert_cache_dir"`
+	HTTPRedirectAddr    string   `yaml:"http_redirect_address"`
+}
+
+// Production reports whether the server runs in production mode, where
+// insecure settings are refused.
+func (c Config) Production() bool {
+	return c.Env == EnvProduction
+}
+
+// TLSEnabled reports whether the server should serve HTTPS.
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" || c.TLSSelfSigned || len(c.TLSAutocertDomains) > 0
+}
+
+func defaults() Config {
+	return Config{
+		Env:                  EnvDevelopment,
+		LogLevel:             "info",
+		LogFormat:            logging.FormatConsole,
+		ServerAddr:           "localhost:8080",
+		BaseURL:              "http://localhost:8080",
+		FileStoragePath:      "short-url-db.json",
+		JWTSecretFile:        "jwt-secret",
+		AnalyticsKeyFile:     "analytics-key",
+		AuthTokenTTL:         30 * 24 * time.Hour,
+		ShortURLStrategy:     "md5",
+		ShortURLLength:       8,
+		ShutdownTimeout:      10 * time.Second,
+		NormalizeCase:        true,
+		NormalizeDefaultPort: true,
+		NormalizeIDN:         true,
+		NormalizePath:        true,
+		AllowedSchemes:       []string{"http", "https"},
+		BlockPrivateNetworks: true,
+		CreateRateBurst:      20,
+		RedirectRateBurst:    100,
+		TLSAutocertCacheDir:  "certs",
+	}
+}
+
+// Load builds the configuration from the defaults, the config file given by
+// -c or CONFIG, the environment and the command line args, each overriding
+// the previous one.
+func Load(args []string, getenv func(string) string) (Config, error) {
+	// The first pass only finds the config file; it also reports bad flags
+	// before anything else is read.
+	var path string
+	scratch := defaults()
+	if err := newFlagSet(&scratch, &path, nil).Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if path == "" {
+		path = getenv("CONFIG")
+	}
+
+	cfg := defaults()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if err := applyEnv(&cfg, getenv); err != nil {
+		return Config{}, err
+	}
+
+	// Flags are bound to the values loaded so far, so only the ones given
+	// explicitly change them.
+	if err := newFlagSet(&cfg, &path, io.Discard).Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	if cfg.TLSEnabled() {
+		cfg.BaseURL = httpsURL(cfg.BaseURL)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+
+	return cfg, nil
 }
 
-func GetConfig() Config {
-	var cfg = Config{}
+func newFlagSet(cfg *Config, path *string, output io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet("shortener", flag.ContinueOnError)
+	if output != nil {
+		fs.SetOutput(output)
+	}
+
+	fs.StringVar(path, "c", *path, "config file in JSON or YAML")
+	fs.StringVar(&cfg.Env, "env", cfg.Env, "environment mode: development or production")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log format: console or json")
+	fs.StringVar(&cfg.ServerAddr, "a", cfg.ServerAddr, "address and port to run server")
+	fs.StringVar(&cfg.BaseURL, "b", cfg.BaseURL, "base url of short links")
+	fs.StringVar(&cfg.FileStoragePath, "f", cfg.FileStoragePath, "file storage path")
+	fs.StringVar(&cfg.DatabaseDSN, "d", cfg.DatabaseDSN, "connect to database")
+	fs.StringVar(&cfg.JWTSecret, "s", cfg.JWTSecret, "JWT secret")
+	fs.StringVar(&cfg.JWTSecretFile, "jwt-secret-file", cfg.JWTSecretFile, "file to read the JWT secret from, generated if missing")
+	fs.Var((*mapValue)(&cfg.JWTKeys), "jwt-keys", "comma separated id:secret JWT keys")
+	fs.StringVar(&cfg.JWTActiveKey, "jwt-active-key", cfg.JWTActiveKey, "id of the JWT key new tokens are signed with")
+	fs.DurationVar(&cfg.AuthTokenTTL, "auth-token-ttl", cfg.AuthTokenTTL, "lifetime of issued auth tokens")
+	fs.StringVar(&cfg.AnalyticsKey, "analytics-key", cfg.AnalyticsKey, "key client IPs of visits are hashed with")
+	fs.StringVar(&cfg.AnalyticsKeyFile, "analytics-key-file", cfg.AnalyticsKeyFile, "file to read the analytics key from, generated if missing")
+	fs.StringVar(&cfg.ShortURLStrategy, "g", cfg.ShortURLStrategy, "short url generator: md5, random, sequential or hashids")
+	fs.IntVar(&cfg.ShortURLLength, "l", cfg.ShortURLLength, "short url length")
+	fs.StringVar(&cfg.ShortURLSalt, "salt", cfg.ShortURLSalt, "salt for the hashids short url generator")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "time to finish in-flight requests on shutdown")
+	fs.BoolVar(&cfg.NormalizeCase, "normalize-case", cfg.NormalizeCase, "lowercase url schemes and hosts")
+	fs.BoolVar(&cfg.NormalizeDefaultPort, "normalize-default-port", cfg.NormalizeDefaultPort, "drop default ports from urls")
+	fs.BoolVar(&cfg.NormalizeIDN, "normalize-idn", cfg.NormalizeIDN, "convert internationalized hosts to punycode")
+	fs.BoolVar(&cfg.NormalizePath, "normalize-path", cfg.NormalizePath, "normalize percent-encoding of url paths")
+	fs.BoolVar(&cfg.NormalizeSortQuery, "normalize-sort-query", cfg.NormalizeSortQuery, "sort url query parameters")
+	fs.BoolVar(&cfg.NormalizeStripTracking, "normalize-strip-tracking", cfg.NormalizeStripTracking, "strip utm_* and other tracking query parameters")
+	fs.Var((*listValue)(&cfg.AllowedSchemes), "allowed-schemes", "comma separated url schemes that can be shortened")
+	fs.StringVar(&cfg.URLBlocklistFile, "url-blocklist", cfg.URLBlocklistFile, "file with domains that cannot be shortened, one per line")
+	fs.BoolVar(&cfg.BlockPrivateNetworks, "block-private", cfg.BlockPrivateNetworks, "refuse urls pointing to private and loopback addresses")
+	fs.Float64Var(&cfg.CreateRateLimit, "rate-create", cfg.CreateRateLimit, "requests per second a client may create links with, 0 to disable")
+	fs.IntVar(&cfg.CreateRateBurst, "rate-create-burst", cfg.CreateRateBurst, "burst of link creation requests")
+	fs.Float64Var(&cfg.RedirectRateLimit, "rate-redirect", cfg.RedirectRateLimit, "redirects per second per client IP, 0 to disable")
+	fs.IntVar(&cfg.RedirectRateBurst, "rate-redirect-burst", cfg.RedirectRateBurst, "burst of redirects")
+	fs.StringVar(&cfg.TLSCertFile, "tls-cert", cfg.TLSCertFile, "TLS certificate file")
+	fs.StringVar(&cfg.TLSKeyFile, "tls-key", cfg.TLSKeyFile, "TLS private key file")
+	fs.BoolVar(&cfg.TLSSelfSigned, "tls-self-signed", cfg.TLSSelfSigned, "serve HTTPS with a generated self-signed certificate")
+	fs.Var((*listValue)(&cfg.TLSAutocertDomains), "tls-autocert", "comma separated domains to get Let's Encrypt certificates for")
+	fs.StringVar(&cfg.TLSAutocertCacheDir, "tls-autocert-cache", cfg.TLSAutocertCacheDir, "directory to cache Let's Encrypt certificates in")
+	fs.StringVar(&cfg.HTTPRedirectAddr, "http-redirect", cfg.HTTPRedirectAddr, "address of a plain HTTP listener redirecting to HTTPS")
+
+	return fs
+}
 
-	flag.StringVar(&cfg.ServerAddr, "a", "localhost:8080", "address and port to run server")
-	flag.StringVar(&cfg.BaseURL, "b", "http://localhost:8080", "")
-	flag.StringVar(&cfg.FileStoragePath, "f", "short-url-db.json", "file storage path")
-	flag.StringVar(&cfg.DatabaseDSN, "d", "", "connect to database")
-	flag.StringVar(&cfg.JWTSecret, "s", "jwt_secret", "JWT secret")
+func applyEnv(cfg *Config, getenv func(string) string) error {
+	if envMode := getenv("APP_ENV"); envMode != "" {
+		cfg.Env = envMode
+	}
+
+	if envLevel := getenv("LOG_LEVEL"); envLevel != "" {
+		cfg.LogLevel = envLevel
+	}
 
-	flag.Parse()
+	if envFormat := getenv("LOG_FORMAT"); envFormat != "" {
+		cfg.LogFormat = envFormat
+	}
 
-	if envAddr := os.Getenv("SERVER_ADDRESS"); envAddr != "" {
+	if envAddr := getenv("SERVER_ADDRESS"); envAddr != "" {
 		cfg.ServerAddr = envAddr
 	}
 
-	if resAddr := os.Getenv("BASE_URL"); resAddr != "" {
+	if resAddr := getenv("BASE_URL"); resAddr != "" {
 		cfg.BaseURL = resAddr
 	}
 
-	if envFileStoragePath := os.Getenv("FILE_STORAGE_PATH"); envFileStoragePath != "" {
+	if envFileStoragePath := getenv("FILE_STORAGE_PATH"); envFileStoragePath != "" {
 		cfg.FileStoragePath = envFileStoragePath
 	}
 
-	if envDatabase := os.Getenv("DATABASE_DSN"); envDatabase != "" {
+	if envDatabase := getenv("DATABASE_DSN"); envDatabase != "" {
 		cfg.DatabaseDSN = envDatabase
 	}
 
-	if envJWTSecret := os.Getenv("JWT_SECRET"); envJWTSecret != "" {
+	if envJWTSecret := getenv("JWT_SECRET"); envJWTSecret != "" {
 		cfg.JWTSecret = envJWTSecret
 	}
 
-	return cfg
+	if envJWTSecretFile := getenv("JWT_SECRET_FILE"); envJWTSecretFile != "" {
+		cfg.JWTSecretFile = envJWTSecretFile
+	}
+
+	if envJWTKeys := getenv("JWT_KEYS"); envJWTKeys != "" {
+		if err := (*mapValue)(&cfg.JWTKeys).Set(envJWTKeys); err != nil {
+			return fmt.Errorf("invalid JWT_KEYS: %w", err)
+		}
+	}
+
+	if envJWTActiveKey := getenv("JWT_ACTIVE_KEY"); envJWTActiveKey != "" {
+		cfg.JWTActiveKey = envJWTActiveKey
+	}
+
+	if envTokenTTL := getenv("AUTH_TOKEN_TTL"); envTokenTTL != "" {
+		ttl, err := time.ParseDuration(envTokenTTL)
+		if err != nil {
+			return fmt.Errorf("invalid AUTH_TOKEN_TTL: %w", err)
+		}
+		cfg.AuthTokenTTL = ttl
+	}
+
+	if envAnalyticsKey := getenv("ANALYTICS_KEY"); envAnalyticsKey != "" {
+		cfg.AnalyticsKey = envAnalyticsKey
+	}
+
+	if envAnalyticsKeyFile := getenv("ANALYTICS_KEY_FILE"); envAnalyticsKeyFile != "" {
+		cfg.AnalyticsKeyFile = envAnalyticsKeyFile
+	}
+
+	if envStrategy := getenv("SHORT_URL_STRATEGY"); envStrategy != "" {
+		cfg.ShortURLStrategy = envStrategy
+	}
+
+	if envLength := getenv("SHORT_URL_LENGTH"); envLength != "" {
+		length, err := strconv.Atoi(envLength)
+		if err != nil {
+			return fmt.Errorf("invalid SHORT_URL_LENGTH: %w", err)
+		}
+		cfg.ShortURLLength = length
+	}
+
+	if envSalt := getenv("SHORT_URL_SALT"); envSalt != "" {
+		cfg.ShortURLSalt = envSalt
+	}
+
+	if envShutdownTimeout := getenv("SHUTDOWN_TIMEOUT"); envShutdownTimeout != "" {
+		timeout, err := time.ParseDuration(envShutdownTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid SHUTDOWN_TIMEOUT: %w", err)
+		}
+		cfg.ShutdownTimeout = timeout
+	}
+
+	normalizeRules := []struct {
+		name  string
+		value *bool
+	}{
+		{"NORMALIZE_CASE", &cfg.NormalizeCase},
+		{"NORMALIZE_DEFAULT_PORT", &cfg.NormalizeDefaultPort},
+		{"NORMALIZE_IDN", &cfg.NormalizeIDN},
+		{"NORMALIZE_PATH", &cfg.NormalizePath},
+		{"NORMALIZE_SORT_QUERY", &cfg.NormalizeSortQuery},
+		{"NORMALIZE_STRIP_TRACKING", &cfg.NormalizeStripTracking},
+	}
+	for _, rule := range normalizeRules {
+		if envRule := getenv(rule.name); envRule != "" {
+			enabled, err := strconv.ParseBool(envRule)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", rule.name, err)
+			}
+			*rule.value = enabled
+		}
+	}
+
+	if envSchemes := getenv("ALLOWED_SCHEMES"); envSchemes != "" {
+		cfg.AllowedSchemes = splitList(envSchemes)
+	}
+
+	if envBlocklist := getenv("URL_BLOCKLIST_FILE"); envBlocklist != "" {
+		cfg.URLBlocklistFile = envBlocklist
+	}
+
+	if envBlockPrivate := getenv("BLOCK_PRIVATE_NETWORKS"); envBlockPrivate != "" {
+		blockPrivate, err := strconv.ParseBool(envBlockPrivate)
+		if err != nil {
+			return fmt.Errorf("invalid BLOCK_PRIVATE_NETWORKS: %w", err)
+		}
+		cfg.BlockPrivateNetworks = blockPrivate
+	}
+
+	rateLimits := []struct {
+		name  string
+		rate  *float64
+		burst *int
+	}{
+		{"CREATE", &cfg.CreateRateLimit, &cfg.CreateRateBurst},
+		{"REDIRECT", &cfg.RedirectRateLimit, &cfg.RedirectRateBurst},
+	}
+	for _, limit := range rateLimits {
+		if envRate := getenv("RATE_LIMIT_" + limit.name); envRate != "" {
+			rate, err := strconv.ParseFloat(envRate, 64)
+			if err != nil {
+				return fmt.Errorf("invalid RATE_LIMIT_%s: %w", limit.name, err)
+			}
+			*limit.rate = rate
+		}
+
+		if envBurst := getenv("RATE_LIMIT_" + limit.name + "_BURST"); envBurst != "" {
+			burst, err := strconv.Atoi(envBurst)
+			if err != nil {
+				return fmt.Errorf("invalid RATE_LIMIT_%s_BURST: %w", limit.name, err)
+			}
+			*limit.burst = burst
+		}
+	}
+
+	if envCertFile := getenv("TLS_CERT_FILE"); envCertFile != "" {
+		cfg.TLSCertFile = envCertFile
+	}
+
+	if envKeyFile := getenv("TLS_KEY_FILE"); envKeyFile != "" {
+		cfg.TLSKeyFile = envKeyFile
+	}
+
+	if envSelfSigned := getenv("TLS_SELF_SIGNED"); envSelfSigned != "" {
+		selfSigned, err := strconv.ParseBool(envSelfSigned)
+		if err != nil {
+			return fmt.Errorf("invalid TLS_SELF_SIGNED: %w", err)
+		}
+		cfg.TLSSelfSigned = selfSigned
+	}
+
+	if envDomains := getenv("TLS_AUTOCERT_DOMAINS"); envDomains != "" {
+		cfg.TLSAutocertDomains = splitList(envDomains)
+	}
+
+	if envCacheDir := getenv("TLS_AUTOCERT_CACHE_DIR"); envCacheDir != "" {
+		cfg.TLSAutocertCacheDir = envCacheDir
+	}
+
+	if envRedirectAddr := getenv("HTTP_REDIRECT_ADDR"); envRedirectAddr != "" {
+		cfg.HTTPRedirectAddr = envRedirectAddr
+	}
+
+	return nil
+}
+
+// listValue is a comma separated flag.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listValue) Set(s string) error {
+	*l = splitList(s)
+	return nil
+}
+
+// mapValue is a comma separated list of key:value pairs.
+type mapValue map[string]string
+
+func (m *mapValue) String() string {
+	if m == nil {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(*m))
+	for k, v := range *m {
+		pairs = append(pairs, k+":"+v)
+	}
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapValue) Set(s string) error {
+	values := map[string]string{}
+	for _, pair := range splitList(s) {
+		k, v, ok := strings.Cut(pair, ":")
+		if !ok || k == "" {
+			return fmt.Errorf("%q is not a key:value pair", pair)
+		}
+		values[k] = v
+	}
+
+	*m = values
+	return nil
+}
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// httpsURL switches a plain HTTP base URL to HTTPS.
+func httpsURL(baseURL string) string {
+	if strings.HasPrefix(baseURL, "http://") {
+		return "https://" + strings.TrimPrefix(baseURL, "http://")
+	}
+
+	return baseURL
 }
@@ -0,0 +1,149 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// insecureJWTSecret is the secret older versions used by default. Tokens
+// signed with it can be forged by anyone.
+const insecureJWTSecret = "jwt_secret"
+
+// MinJWTSecretLength is the shortest secret accepted in production.
+const MinJWTSecretLength = 32
+
+// How long to wait for a secret file another instance is writing.
+const (
+	concurrentReadAttempts = 10
+	concurrentReadDelay    = 50 * time.Millisecond
+)
+
+// EnsureJWTSecret fills in cfg.JWTSecret when no JWT key is configured. The secret
+// is read from cfg.JWTSecretFile, or generated and written there on first
+// start so that issued tokens stay valid across restarts.
+func EnsureJWTSecret(cfg *Config) error {
+	if cfg.JWTSecret != "" || len(cfg.JWTKeys) > 0 {
+		return nil
+	}
+
+	return cfg.ensureSecret(&cfg.JWTSecret, cfg.JWTSecretFile, "jwt secret")
+}
+
+// EnsureAnalyticsKey fills in cfg.AnalyticsKey the way EnsureJWTSecret does,
+// from cfg.AnalyticsKeyFile, so that IP hashes of visits stay comparable
+// across restarts.
+func EnsureAnalyticsKey(cfg *Config) error {
+	if cfg.AnalyticsKey != "" {
+		return nil
+	}
+
+	return cfg.ensureSecret(&cfg.AnalyticsKey, cfg.AnalyticsKeyFile, "analytics key")
+}
+
+// ensureSecret reads the secret called name from path into secret, or
+// generates one and writes it there if the file does not exist.
+func (c Config) ensureSecret(secret *string, path, name string) error {
+	if path == "" {
+		return fmt.Errorf("%s is not set and there is no %s file to keep one in", name, name)
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		*secret = strings.TrimSpace(string(data))
+		if *secret == "" {
+			// Another instance may have just created the file.
+			if *secret, err = readConcurrentSecret(path, name); err != nil {
+				return err
+			}
+		}
+		return c.validateSecret(name, *secret)
+	case errors.Is(err, os.ErrNotExist):
+		*secret, err = generateSecret(path, name)
+		return err
+	default:
+		return fmt.Errorf("failed to read %s file: %w", name, err)
+	}
+}
+
+func generateSecret(path, name string) (string, error) {
+	b := make([]byte, MinJWTSecretLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate %s: %w", name, err)
+	}
+	secret := hex.EncodeToString(b)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", err
+	}
+
+	// O_EXCL keeps a secret written by a concurrently starting instance,
+	// which is then used instead of ours.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return readConcurrentSecret(path, name)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s file: %w", name, err)
+	}
+
+	if _, err := f.WriteString(secret + "\n"); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write %s file: %w", name, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write %s file: %w", name, err)
+	}
+
+	return secret, nil
+}
+
+// readConcurrentSecret reads the secret another instance has just created the
+// file for. The file may still be empty while that instance writes it, so
+// reading is retried for a short while.
+func readConcurrentSecret(path, name string) (string, error) {
+	for attempt := 0; ; attempt++ {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s file: %w", name, err)
+		}
+
+		if secret := strings.TrimSpace(string(data)); secret != "" {
+			return secret, nil
+		}
+
+		if attempt == concurrentReadAttempts {
+			return "", fmt.Errorf("%s file %s is still empty", name, path)
+		}
+		time.Sleep(concurrentReadDelay)
+	}
+}
+
+// validateSecret refuses empty secrets and, in production, short ones and
+// the old default.
+func (c Config) validateSecret(name, secret string) error {
+	if secret == "" {
+		return fmt.Errorf("%s must not be empty", name)
+	}
+
+	if !c.Production() {
+		return nil
+	}
+
+	if secret == insecureJWTSecret {
+		return fmt.Errorf("the default jwt secret must not be used as %s in production", name)
+	}
+
+	if len(secret) < MinJWTSecretLength {
+		return fmt.Errorf("%s must be at least %d bytes in production", name, MinJWTSecretLength)
+	}
+
+	return nil
+}
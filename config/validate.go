@@ -0,0 +1,91 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"net"
+	"net/url"
+	"shortener/internal/logging"
+)
+
+// Validate reports the first setting the server cannot start with.
+func (c Config) Validate() error {
+	if c.Env != EnvDevelopment && c.Env != EnvProduction {
+		return fmt.Errorf("unknown environment mode %q", c.Env)
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+
+	if c.LogFormat != logging.FormatConsole && c.LogFormat != logging.FormatJSON {
+		return fmt.Errorf("unknown log format %q", c.LogFormat)
+	}
+
+	if _, _, err := net.SplitHostPort(c.ServerAddr); err != nil {
+		return fmt.Errorf("invalid server address %q: %w", c.ServerAddr, err)
+	}
+
+	u, err := url.Parse(c.BaseURL)
+	if err != nil {
+		return fmt.Errorf("invalid base url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid base url %q: must be an absolute http or https url", c.BaseURL)
+	}
+
+	// An empty secret is filled in later by EnsureJWTSecret.
+	if c.JWTSecret != "" {
+		if err := c.validateSecret("jwt secret", c.JWTSecret); err != nil {
+			return err
+		}
+	}
+
+	// An empty key is filled in later by EnsureAnalyticsKey.
+	if c.AnalyticsKey != "" {
+		if err := c.validateSecret("analytics key", c.AnalyticsKey); err != nil {
+			return err
+		}
+	}
+
+	for id, secret := range c.JWTKeys {
+		if err := c.validateSecret(fmt.Sprintf("jwt key %q", id), secret); err != nil {
+			return err
+		}
+	}
+
+	if c.AuthTokenTTL <= 0 {
+		return fmt.Errorf("auth token ttl must be positive, got %v", c.AuthTokenTTL)
+	}
+
+	if c.ShortURLLength <= 0 {
+		return fmt.Errorf("short url length must be positive, got %d", c.ShortURLLength)
+	}
+
+	if c.ShutdownTimeout < 0 {
+		return fmt.Errorf("shutdown timeout must not be negative, got %v", c.ShutdownTimeout)
+	}
+
+	if len(c.AllowedSchemes) == 0 {
+		return errors.New("at least one url scheme must be allowed")
+	}
+
+	if c.CreateRateLimit < 0 || c.RedirectRateLimit < 0 {
+		return errors.New("rate limits must not be negative")
+	}
+
+	if (c.CreateRateLimit > 0 && c.CreateRateBurst < 1) || (c.RedirectRateLimit > 0 && c.RedirectRateBurst < 1) {
+		return errors.New("rate limit bursts must be positive")
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("tls cert and key files must be set together")
+	}
+
+	if c.HTTPRedirectAddr != "" && !c.TLSEnabled() {
+		return errors.New("http redirect address requires TLS")
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package account registers users and signs them in, handing the links of
+// their anonymous identity over to the account.
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	minLoginLength    = 3
+	maxLoginLength    = 64
+	minPasswordLength = 8
+	// maxPasswordLength is the most bcrypt looks at.
+	maxPasswordLength = 72
+)
+
+var (
+	ErrInvalidLogin       = errors.New("login must be 3 to 64 characters long")
+	ErrInvalidPassword    = errors.New("password must be 8 to 72 bytes long")
+	ErrLoginTaken         = errors.New("login is already taken")
+	ErrInvalidCredentials = errors.New("invalid login or password")
+)
+
+// dummyHash is compared against when the login is unknown, so that a failed
+// login takes as long whether the login exists or not.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+
+type Service struct {
+	store storage.UserStorage
+	cost  int
+}
+
+func NewService(store storage.UserStorage) *Service {
+	return &Service{store: store, cost: bcrypt.DefaultCost}
+}
+
+// Register creates an account and moves the links of anonymousID to it. Both
+// happen together, so a failed request can simply be retried.
+func (s *Service) Register(ctx context.Context, creds models.Credentials, anonymousID string) (models.User, error) {
+	login := normalizeLogin(creds.Login)
+	if n := utf8.RuneCountInString(login); n < minLoginLength || n > maxLoginLength {
+		return models.User{}, ErrInvalidLogin
+	}
+
+	if len(creds.Password) < minPasswordLength || len(creds.Password) > maxPasswordLength {
+		return models.User{}, ErrInvalidPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(creds.Password), s.cost)
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := models.User{ID: uuid.NewString(), Login: login, CreatedAt: time.Now().UTC()}
+	err = s.store.CreateUserAndClaim(ctx, user, hash, anonymousID)
+	if errors.Is(err, storage.ErrConflict) {
+		return models.User{}, ErrLoginTaken
+	}
+	if err != nil {
+		return models.User{}, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+// Login checks the credentials and moves the links of anonymousID to the
+// account.
+func (s *Service) Login(ctx context.Context, creds models.Credentials, anonymousID string) (models.User, error) {
+	user, hash, err := s.store.GetUserByLogin(ctx, normalizeLogin(creds.Login))
+	if errors.Is(err, storage.ErrNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(creds.Password))
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(creds.Password)); err != nil {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	return user, s.claim(ctx, anonymousID, user.ID)
+}
+
+func (s *Service) claim(ctx context.Context, anonymousID, userID string) error {
+	if anonymousID == "" || anonymousID == userID {
+		return nil
+	}
+
+	if _, err := s.store.ClaimURLs(ctx, anonymousID, userID); err != nil {
+		return fmt.Errorf("failed to claim anonymous links: %w", err)
+	}
+
+	return nil
+}
+
+func normalizeLogin(login string) string {
+	return strings.ToLower(strings.TrimSpace(login))
+}
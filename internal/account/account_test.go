@@ -0,0 +1,116 @@
+package account
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"testing"
+)
+
+type userStore struct {
+	users  map[string]models.User
+	hashes map[string][]byte
+	owners map[string]string
+}
+
+func newUserStore() *userStore {
+	return &userStore{users: map[string]models.User{}, hashes: map[string][]byte{}, owners: map[string]string{}}
+}
+
+func (s *userStore) CreateUserAndClaim(ctx context.Context, user models.User, passwordHash []byte, anonymousID string) error {
+	if _, ok := s.users[user.Login]; ok {
+		return storage.ErrConflict
+	}
+	s.users[user.Login] = user
+	s.hashes[user.Login] = passwordHash
+	if anonymousID != "" {
+		_, _ = s.ClaimURLs(ctx, anonymousID, user.ID)
+	}
+	return nil
+}
+
+func (s *userStore) GetUserByLogin(ctx context.Context, login string) (models.User, []byte, error) {
+	user, ok := s.users[login]
+	if !ok {
+		return models.User{}, nil, storage.ErrNotFound
+	}
+	return user, s.hashes[login], nil
+}
+
+func (s *userStore) ClaimURLs(ctx context.Context, anonymousID, userID string) (int64, error) {
+	var n int64
+	for link, owner := range s.owners {
+		if owner == anonymousID {
+			s.owners[link] = userID
+			n++
+		}
+	}
+	return n, nil
+}
+
+func newTestService(store storage.UserStorage) *Service {
+	s := NewService(store)
+	s.cost = bcrypt.MinCost
+	return s
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	ctx := context.Background()
+	store := newUserStore()
+	store.owners["link1"] = "anon1"
+	store.owners["link2"] = "anon2"
+	s := newTestService(store)
+
+	user, err := s.Register(ctx, models.Credentials{Login: " Alice ", Password: "correct horse"}, "anon1")
+	require.NoError(t, err)
+	assert.Equal(t, "alice", user.Login)
+	assert.Equal(t, user.ID, store.owners["link1"])
+
+	_, err = s.Register(ctx, models.Credentials{Login: "ALICE", Password: "another password"}, "anon3")
+	assert.ErrorIs(t, err, ErrLoginTaken)
+
+	_, err = s.Login(ctx, models.Credentials{Login: "alice", Password: "wrong password"}, "anon2")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	assert.Equal(t, "anon2", store.owners["link2"])
+
+	_, err = s.Login(ctx, models.Credentials{Login: "bob", Password: "correct horse"}, "anon2")
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	loggedIn, err := s.Login(ctx, models.Credentials{Login: "Alice", Password: "correct horse"}, "anon2")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, loggedIn.ID)
+	assert.Equal(t, user.ID, store.owners["link2"])
+}
+
+func TestRegisterValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		creds   models.Credentials
+		wantErr error
+	}{
+		{
+			name:    "short login",
+			creds:   models.Credentials{Login: "al", Password: "correct horse"},
+			wantErr: ErrInvalidLogin,
+		},
+		{
+			name:    "short password",
+			creds:   models.Credentials{Login: "alice", Password: "short"},
+			wantErr: ErrInvalidPassword,
+		},
+		{
+			name:    "long password",
+			creds:   models.Credentials{Login: "alice", Password: string(make([]byte, maxPasswordLength+1))},
+			wantErr: ErrInvalidPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestService(newUserStore()).Register(context.Background(), tt.creds, "anon")
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+// Package analytics records redirects in the background so that the
+// redirect handler never waits for storage.
+package analytics
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"time"
+)
+
+const (
+	queueSize     = 1024
+	batchSize     = 100
+	flushInterval = time.Second
+)
+
+type Recorder struct {
+	store  storage.VisitStorage
+	key    []byte
+	visits chan models.Visit
+	logger *zap.SugaredLogger
+}
+
+// NewRecorder returns a recorder that hashes client IPs with key. Visits are
+// only saved while Run is running.
+func NewRecorder(store storage.VisitStorage, key []byte, logger *zap.SugaredLogger) *Recorder {
+	return &Recorder{
+		store:  store,
+		key:    key,
+		visits: make(chan models.Visit, queueSize),
+		logger: logger,
+	}
+}
+
+// Record queues a visit of shortURL. It never blocks: when the queue is full
+// the visit is dropped.
+func (rec *Recorder) Record(r *http.Request, shortURL string) {
+	if rec == nil {
+		return
+	}
+
+	visit := models.Visit{
+		ShortURL:  shortURL,
+		VisitedAt: time.Now().UTC(),
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    rec.hashIP(r.RemoteAddr),
+	}
+
+	select {
+	case rec.visits <- visit:
+	default:
+		rec.logger.Warnw("visit queue is full, dropping visit", "short_url", shortURL)
+	}
+}
+
+// Pending returns the number of visits waiting in the queue.
+func (rec *Recorder) Pending() int {
+	if rec == nil {
+		return 0
+	}
+
+	return len(rec.visits)
+}
+
+// Run saves queued visits in batches until ctx is done, then flushes what is
+// left in the queue.
+func (rec *Recorder) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]models.Visit, 0, batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+
+		// Use a fresh context so the final flush is not cancelled with ctx.
+		if err := rec.store.SaveVisits(context.Background(), batch); err != nil {
+			rec.logger.Errorw("failed to save visits", "count", len(batch), "err", err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case v := <-rec.visits:
+			batch = append(batch, v)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-ctx.Done():
+			for {
+				select {
+				case v := <-rec.visits:
+					batch = append(batch, v)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (rec *Recorder) hashIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	mac := hmac.New(sha256.New, rec.key)
+	mac.Write([]byte(host))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"shortener/internal/middleware/logger"
+	mapStorage "shortener/internal/storage/map"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "abc", "https://example.com", "user", nil))
+
+	l, _ := logger.NewLogger()
+	rec := NewRecorder(store, []byte("key"), l)
+
+	r := httptest.NewRequest("GET", "/abc", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+	r.Header.Set("Referer", "https://ref.example.com")
+	for i := 0; i < 3; i++ {
+		rec.Record(r, "abc")
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		rec.Run(runCtx)
+		close(done)
+	}()
+	cancel()
+	<-done
+
+	stats, err := store.GetStats(ctx, "abc", "user")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+}
+
+func TestRecordDoesNotBlock(t *testing.T) {
+	l, _ := logger.NewLogger()
+	rec := NewRecorder(nil, []byte("key"), l)
+
+	r := httptest.NewRequest("GET", "/abc", nil)
+	for i := 0; i < queueSize+10; i++ {
+		rec.Record(r, "abc")
+	}
+
+	assert.Len(t, rec.visits, queueSize)
+}
+
+func TestHashIP(t *testing.T) {
+	rec := NewRecorder(nil, []byte("key"), nil)
+
+	hash := rec.hashIP("192.0.2.1:1234")
+	assert.Equal(t, hash, rec.hashIP("192.0.2.1:5678"), "port must not affect the hash")
+	assert.NotEqual(t, hash, rec.hashIP("192.0.2.2:1234"))
+	assert.NotContains(t, hash, "192.0.2.1")
+}
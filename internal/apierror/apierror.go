@@ -0,0 +1,76 @@
+// Package apierror writes the error responses of the API, both from handlers
+// and from middleware.
+package apierror
+
+import (
+	"encoding/json"
+	"math"
+	"mime"
+	"net/http"
+	"shortener/internal/logging"
+	"shortener/internal/models"
+	"strconv"
+	"strings"
+)
+
+// Write answers with e as JSON or, when the client prefers it, as plain text.
+// The ID of the request is filled in, so that clients can quote it.
+func Write(w http.ResponseWriter, r *http.Request, status int, e models.Error) {
+	e.RequestID = logging.RequestID(r.Context())
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if prefersText(r.Header.Get("Accept")) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(status)
+
+		message := e.Message
+		if e.RequestID != "" {
+			message += " (request id " + e.RequestID + ")"
+		}
+		_, _ = w.Write([]byte(message + "\n"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(models.ErrorResponse{Error: e})
+}
+
+// Internal answers with a generic error that does not leak any details.
+func Internal(w http.ResponseWriter, r *http.Request) {
+	Write(w, r, http.StatusInternalServerError, models.Error{
+		Code:    models.ErrCodeInternal,
+		Message: "Internal server error",
+	})
+}
+
+// prefersText reports whether the Accept header rates plain text or HTML
+// above JSON. JSON wins ties, so clients that send no Accept header or */*
+// get JSON.
+func prefersText(accept string) bool {
+	var jsonQ, textQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/json", "application/*", "*/*":
+			jsonQ = math.Max(jsonQ, q)
+		case "text/plain", "text/html", "text/*":
+			textQ = math.Max(textQ, q)
+		}
+	}
+
+	return textQ > jsonQ
+}
@@ -0,0 +1,27 @@
+package apierror
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPrefersText(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{accept: "", want: false},
+		{accept: "*/*", want: false},
+		{accept: "application/json", want: false},
+		{accept: "text/plain", want: true},
+		{accept: "text/plain;q=0.5, application/json", want: false},
+		{accept: "application/json;q=0.5, text/*", want: true},
+		{accept: "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", want: true},
+		{accept: "text/plain, application/json", want: false},
+	}
+	for _, test := range tests {
+		t.Run(test.accept, func(t *testing.T) {
+			assert.Equal(t, test.want, prefersText(test.accept))
+		})
+	}
+}
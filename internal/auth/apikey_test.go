@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"shortener/config"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"testing"
+	"time"
+)
+
+type apiKeyStore map[string]models.APIKey
+
+func (s apiKeyStore) UseAPIKey(ctx context.Context, keyHash string, now time.Time) (models.APIKey, error) {
+	key, ok := s[keyHash]
+	if !ok {
+		return models.APIKey{}, errs.ErrNotFound
+	}
+	return key, nil
+}
+
+func TestWithAuthAPIKey(t *testing.T) {
+	cfg := config.Config{JWTSecret: "secret", AuthTokenTTL: time.Hour}
+	keys, err := NewKeyring(cfg)
+	require.NoError(t, err)
+
+	key, keyHash, err := GenerateAPIKey()
+	require.NoError(t, err)
+	store := apiKeyStore{keyHash: {ID: "id", UserID: "owner", Scopes: []string{ScopeShorten}}}
+
+	tests := []struct {
+		name     string
+		store    APIKeyStore
+		key      string
+		scope    string
+		wantCode int
+		wantErr  string
+	}{
+		{
+			name:     "valid key",
+			store:    store,
+			key:      key,
+			scope:    ScopeShorten,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "missing scope",
+			store:    store,
+			key:      key,
+			scope:    ScopeDelete,
+			wantCode: http.StatusForbidden,
+			wantErr:  models.ErrCodeForbidden,
+		},
+		{
+			name:     "unknown key",
+			store:    store,
+			key:      key + "x",
+			scope:    ScopeShorten,
+			wantCode: http.StatusUnauthorized,
+			wantErr:  models.ErrCodeUnauthorized,
+		},
+		{
+			name:     "no key storage",
+			key:      key,
+			scope:    ScopeShorten,
+			wantCode: http.StatusUnauthorized,
+			wantErr:  models.ErrCodeUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID string
+			h := WithAuth(RequireScope(tt.scope)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, _ = r.Context().Value(UserIDContextKey).(string)
+			})), keys, tt.store, cfg, zap.NewNop().Sugar())
+
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.Header.Set(APIKeyHeader, tt.key)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			assert.Empty(t, res.Cookies())
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, "owner", userID)
+				return
+			}
+
+			var body models.ErrorResponse
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, tt.wantErr, body.Error.Code)
+		})
+	}
+}
+
+func TestRequireScopeWithoutAPIKey(t *testing.T) {
+	h := RequireScope(ScopeKeys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestValidateScopes(t *testing.T) {
+	assert.NoError(t, ValidateScopes([]string{ScopeShorten, ScopeRead, ScopeDelete, ScopeKeys}))
+	assert.ErrorIs(t, ValidateScopes([]string{ScopeRead, "admin"}), ErrUnknownScope)
+}
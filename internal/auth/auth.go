@@ -9,90 +9,218 @@ import (
 	"go.uber.org/zap"
 	"net/http"
 	"shortener/config"
+	"shortener/internal/apierror"
+	"shortener/internal/logging"
+	"shortener/internal/models"
+	"strings"
+	"time"
 )
 
+const cookieName = "AuthToken"
+
+// TokenHeader carries every token the server issues, so that clients that do
+// not keep cookies can switch to it.
+const TokenHeader = "X-Auth-Token"
+
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID string
+	// Registered marks tokens of users who signed in to an account.
+	Registered bool `json:",omitempty"`
 }
 
 type contextKey int
 
-const UserIDContextKey contextKey = iota
+const (
+	UserIDContextKey contextKey = iota
+	// ScopesContextKey holds the scopes of the API key a request was made
+	// with. It is absent for requests authenticated otherwise.
+	ScopesContextKey
+	// RegisteredContextKey is true for requests made with the token of a
+	// registered user.
+	RegisteredContextKey
+	// AnonymousContextKey is true for requests identified by the token of a
+	// user without an account, whether it came in a cookie or a bearer
+	// header. Such identities are handed out to anyone who asks, so they say
+	// nothing about the client.
+	AnonymousContextKey
+)
 
-func WithAuth(h http.Handler, cfg config.Config, logger *zap.SugaredLogger) http.Handler {
+// WithAuth identifies the user by an X-API-Key header, an "Authorization:
+// Bearer" token or, if there is neither, by the AuthToken cookie. Cookie
+// requests without a token or with an expired or unverifiable one get a new
+// anonymous identity, while a bad bearer token is rejected. Tokens past half
+// of their lifetime are re-issued so that active users stay signed in.
+func WithAuth(h http.Handler, keys *Keyring, apiKeys APIKeyStore, cfg config.Config, logger *zap.SugaredLogger) http.Handler {
 	authMiddleware := func(w http.ResponseWriter, r *http.Request) {
-		authToken, err := r.Cookie("AuthToken")
-		if err != nil {
-			if errors.Is(err, http.ErrNoCookie) {
-				id := uuid.NewString()
-				token, err := generateJWTToken(id, cfg)
-				if err != nil {
-					logger.Errorf("Failed to get token string: %v", err)
-					w.WriteHeader(http.StatusInternalServerError)
-					return
-				}
-				ctx := context.WithValue(r.Context(), UserIDContextKey, id)
-				setAuthCookie(w, token)
-				h.ServeHTTP(w, r.WithContext(ctx))
+		if key := r.Header.Get(APIKeyHeader); key != "" {
+			authenticateAPIKey(w, r, h, key, apiKeys, logger)
+			return
+		}
+
+		bearer, isBearer := bearerToken(r)
+
+		var claims *Claims
+		var refresh bool
+		var err error
+		if isBearer {
+			claims, refresh, err = userFromToken(bearer, keys, cfg.AuthTokenTTL)
+			if err != nil {
+				logger.Infow("Rejected bearer token", "err", err)
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				apierror.Write(w, r, http.StatusUnauthorized, models.Error{Code: models.ErrCodeUnauthorized, Message: "Invalid bearer token"})
 				return
 			}
+		} else {
+			claims, refresh, err = userFromCookie(r, keys, cfg.AuthTokenTTL)
+			if err != nil {
+				// The client cannot fix a cookie it did not make, for example
+				// one signed with a key that has since been retired, so it
+				// is replaced like a missing one.
+				logger.Infow("Replacing unverifiable auth cookie", "err", err)
+				claims = nil
+			}
+		}
 
-			logger.Errorf("Failed to get AuthToken: %v", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+		if claims == nil {
+			claims = &Claims{UserID: uuid.NewString()}
+			refresh = true
 		}
 
-		userID := GetUserIDFromJWTToken(authToken.Value, cfg)
-		if userID == "" {
-			logger.Errorw("Failed to parse userID from jwt token")
-			w.WriteHeader(http.StatusUnauthorized)
-			return
+		if refresh {
+			token, _, err := IssueToken(claims.UserID, claims.Registered, keys, cfg.AuthTokenTTL)
+			if err != nil {
+				logger.Errorf("Failed to get token string: %v", err)
+				apierror.Internal(w, r)
+				return
+			}
+
+			w.Header().Set(TokenHeader, token)
+			if !isBearer {
+				setAuthCookie(w, token, cfg)
+			}
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDContextKey, userID)
-		setAuthCookie(w, authToken.Value)
+		logging.SetUserID(r.Context(), claims.UserID)
+		ctx := context.WithValue(r.Context(), UserIDContextKey, claims.UserID)
+		ctx = context.WithValue(ctx, RegisteredContextKey, claims.Registered)
+		if !claims.Registered {
+			ctx = context.WithValue(ctx, AnonymousContextKey, true)
+		}
 		h.ServeHTTP(w, r.WithContext(ctx))
 	}
 
 	return http.HandlerFunc(authMiddleware)
 }
 
-func generateJWTToken(id string, cfg config.Config) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{
-		UserID: id,
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// userFromCookie returns the claims of the request's token and whether the
+// token should be re-issued. Nil claims without an error mean the request
+// has no usable token.
+func userFromCookie(r *http.Request, keys *Keyring, ttl time.Duration) (*Claims, bool, error) {
+	cookie, err := r.Cookie(cookieName)
+	if errors.Is(err, http.ErrNoCookie) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	claims, refresh, err := userFromToken(cookie.Value, keys, ttl)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, false, nil
+	}
+
+	return claims, refresh, err
+}
+
+func userFromToken(token string, keys *Keyring, ttl time.Duration) (*Claims, bool, error) {
+	claims, err := parseJWTToken(token, keys)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Tokens issued before expiry was introduced have no exp and are
+	// replaced by expiring ones.
+	refresh := claims.ExpiresAt == nil || time.Until(claims.ExpiresAt.Time) < ttl/2
+
+	return claims, refresh, nil
+}
+
+// IssueToken signs a token for userID that expires after ttl. registered
+// tells whether userID belongs to an account.
+func IssueToken(userID string, registered bool, keys *Keyring, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+	tokenString, err := keys.sign(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+		},
+		UserID:     userID,
+		Registered: registered,
 	})
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate token string: %w", err)
+	}
 
-	tokenString, err := token.SignedString([]byte(cfg.JWTSecret))
+	return tokenString, expiresAt, nil
+}
+
+// StartSession issues a token for the registered user userID and hands it
+// out like WithAuth does, in TokenHeader and in the AuthToken cookie.
+func StartSession(w http.ResponseWriter, userID string, keys *Keyring, cfg config.Config) (models.TokenResponse, error) {
+	token, expiresAt, err := IssueToken(userID, true, keys, cfg.AuthTokenTTL)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate token string: %w", err)
+		return models.TokenResponse{}, err
 	}
 
-	return tokenString, nil
+	w.Header().Set(TokenHeader, token)
+	setAuthCookie(w, token, cfg)
+
+	return models.TokenResponse{Token: token, ExpiresAt: expiresAt}, nil
 }
 
-func GetUserIDFromJWTToken(tokenString string, cfg config.Config) string {
+func parseJWTToken(tokenString string, keys *Keyring) (*Claims, error) {
 	claims := &Claims{}
-	token, err := jwt.ParseWithClaims(tokenString, claims,
-		func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
-			}
-			return []byte(cfg.JWTSecret), nil
-		})
-
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc)
 	if err != nil {
-		return ""
+		return nil, err
 	}
 
-	if !token.Valid {
+	if !token.Valid || claims.UserID == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	return claims, nil
+}
+
+func GetUserIDFromJWTToken(tokenString string, keys *Keyring) string {
+	claims, err := parseJWTToken(tokenString, keys)
+	if err != nil {
 		return ""
 	}
 
 	return claims.UserID
 }
 
-func setAuthCookie(w http.ResponseWriter, token string) {
-	cookie := &http.Cookie{Name: "AuthToken", Value: token}
+func setAuthCookie(w http.ResponseWriter, token string, cfg config.Config) {
+	cookie := &http.Cookie{
+		Name:     cookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(cfg.AuthTokenTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
 	http.SetCookie(w, cookie)
 }
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"shortener/config"
+	"testing"
+	"time"
+)
+
+func TestWithAuth(t *testing.T) {
+	cfg := config.Config{BaseURL: "https://short.example", JWTSecret: "secret", AuthTokenTTL: time.Hour}
+	keys, err := NewKeyring(cfg)
+	require.NoError(t, err)
+
+	signedAs := func(ttl time.Duration, registered bool) string {
+		token, err := keys.sign(Claims{
+			RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl))},
+			UserID:           "user",
+			Registered:       registered,
+		})
+		require.NoError(t, err)
+		return token
+	}
+	signed := func(ttl time.Duration) string {
+		return signedAs(ttl, false)
+	}
+	legacy, err := keys.sign(Claims{UserID: "user"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		token         string
+		bearer        string
+		wantCode      int
+		wantSameID    bool
+		wantRefresh   bool
+		wantAnonymous bool
+	}{
+		{
+			name:          "no cookie",
+			wantCode:      http.StatusOK,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "fresh token",
+			token:         signed(time.Hour),
+			wantCode:      http.StatusOK,
+			wantSameID:    true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "token near expiry",
+			token:         signed(time.Minute),
+			wantCode:      http.StatusOK,
+			wantSameID:    true,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "token without expiry",
+			token:         legacy,
+			wantCode:      http.StatusOK,
+			wantSameID:    true,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "expired token",
+			token:         signed(-time.Minute),
+			wantCode:      http.StatusOK,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:        "registered token near expiry",
+			token:       signedAs(time.Minute, true),
+			wantCode:    http.StatusOK,
+			wantSameID:  true,
+			wantRefresh: true,
+		},
+		{
+			name:          "forged token",
+			token:         signed(time.Hour) + "x",
+			wantCode:      http.StatusOK,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "bearer token",
+			bearer:        signed(time.Hour),
+			wantCode:      http.StatusOK,
+			wantSameID:    true,
+			wantAnonymous: true,
+		},
+		{
+			name:          "bearer token near expiry",
+			bearer:        signed(time.Minute),
+			wantCode:      http.StatusOK,
+			wantSameID:    true,
+			wantRefresh:   true,
+			wantAnonymous: true,
+		},
+		{
+			name:       "registered bearer token",
+			bearer:     signedAs(time.Hour, true),
+			wantCode:   http.StatusOK,
+			wantSameID: true,
+		},
+		{
+			name:     "bearer token wins over cookie",
+			token:    signed(time.Hour),
+			bearer:   signed(time.Hour) + "x",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "expired bearer token",
+			bearer:   signed(-time.Minute),
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var userID string
+			var anonymous bool
+			h := WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID, _ = r.Context().Value(UserIDContextKey).(string)
+				anonymous, _ = r.Context().Value(AnonymousContextKey).(bool)
+			}), keys, nil, cfg, zap.NewNop().Sugar())
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.token != "" {
+				r.AddCookie(&http.Cookie{Name: cookieName, Value: tt.token})
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode)
+			if tt.wantCode != http.StatusOK {
+				assert.Empty(t, res.Cookies())
+				return
+			}
+
+			assert.NotEmpty(t, userID)
+			assert.Equal(t, tt.wantSameID, userID == "user")
+			assert.Equal(t, tt.wantAnonymous, anonymous)
+
+			cookies := res.Cookies()
+			if !tt.wantRefresh {
+				assert.Empty(t, cookies)
+				assert.Empty(t, res.Header.Get(TokenHeader))
+				return
+			}
+
+			token := res.Header.Get(TokenHeader)
+			claims, err := parseJWTToken(token, keys)
+			require.NoError(t, err)
+			assert.Equal(t, userID, claims.UserID)
+			assert.Equal(t, !tt.wantAnonymous, claims.Registered)
+			if tt.bearer != "" {
+				assert.Empty(t, cookies)
+				return
+			}
+
+			require.Len(t, cookies, 1)
+			c := cookies[0]
+			assert.True(t, c.HttpOnly)
+			assert.True(t, c.Secure)
+			assert.Equal(t, http.SameSiteLaxMode, c.SameSite)
+			assert.Equal(t, "/", c.Path)
+			assert.Equal(t, 3600, c.MaxAge)
+			assert.Equal(t, token, c.Value)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"shortener/config"
+)
+
+// LegacyKeyID names the key configured by config.Config.JWTSecret. Tokens
+// issued before key IDs were introduced have no kid header and are checked
+// against it.
+const LegacyKeyID = "default"
+
+// Keyring signs tokens with the active key and verifies them with any key it
+// holds, so that a key can be rotated out without logging everybody out.
+type Keyring struct {
+	activeID string
+	keys     map[string][]byte
+}
+
+// NewKeyring builds the keyring from cfg.JWTKeys and cfg.JWTSecret. The
+// active key is cfg.JWTActiveKey; it may be omitted when there is only one
+// key.
+func NewKeyring(cfg config.Config) (*Keyring, error) {
+	keys := make(map[string][]byte, len(cfg.JWTKeys)+1)
+	for id, secret := range cfg.JWTKeys {
+		keys[id] = []byte(secret)
+	}
+
+	if cfg.JWTSecret != "" {
+		if _, ok := keys[LegacyKeyID]; ok {
+			return nil, fmt.Errorf("jwt key %q is configured both as a key and as the jwt secret", LegacyKeyID)
+		}
+		keys[LegacyKeyID] = []byte(cfg.JWTSecret)
+	}
+
+	activeID := cfg.JWTActiveKey
+	if activeID == "" {
+		if len(keys) != 1 {
+			return nil, errors.New("jwt active key must be set when there is not exactly one jwt key")
+		}
+		for id := range keys {
+			activeID = id
+		}
+	}
+
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("jwt active key %q is not configured", activeID)
+	}
+
+	return &Keyring{activeID: activeID, keys: keys}, nil
+}
+
+func (k *Keyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = k.activeID
+
+	return token.SignedString(k.keys[k.activeID])
+}
+
+func (k *Keyring) keyFunc(t *jwt.Token) (interface{}, error) {
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+	}
+
+	id := LegacyKeyID
+	if kid, ok := t.Header["kid"]; ok {
+		if id, ok = kid.(string); !ok {
+			return nil, fmt.Errorf("unexpected kid: %v", kid)
+		}
+	}
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", id)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/config"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	old, err := NewKeyring(config.Config{JWTKeys: map[string]string{"k1": "secret1"}})
+	require.NoError(t, err)
+
+	oldToken, _, err := IssueToken("user", false, old, time.Hour)
+	require.NoError(t, err)
+
+	rotated, err := NewKeyring(config.Config{
+		JWTKeys:      map[string]string{"k1": "secret1", "k2": "secret2"},
+		JWTActiveKey: "k2",
+	})
+	require.NoError(t, err)
+
+	newToken, _, err := IssueToken("user", false, rotated, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, "user", GetUserIDFromJWTToken(oldToken, rotated))
+	assert.Equal(t, "user", GetUserIDFromJWTToken(newToken, rotated))
+
+	retired, err := NewKeyring(config.Config{JWTKeys: map[string]string{"k2": "secret2"}})
+	require.NoError(t, err)
+
+	assert.Empty(t, GetUserIDFromJWTToken(oldToken, retired))
+	assert.Equal(t, "user", GetUserIDFromJWTToken(newToken, retired))
+}
+
+func TestKeyringLegacyToken(t *testing.T) {
+	// Tokens issued before key IDs carry no kid and use the jwt secret.
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, Claims{UserID: "user"}).SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	keys, err := NewKeyring(config.Config{
+		JWTSecret:    "secret",
+		JWTKeys:      map[string]string{"k1": "secret1"},
+		JWTActiveKey: "k1",
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "user", GetUserIDFromJWTToken(legacy, keys))
+}
+
+func TestNewKeyring(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantErr bool
+	}{
+		{
+			name: "jwt secret only",
+			cfg:  config.Config{JWTSecret: "secret"},
+		},
+		{
+			name:    "no keys",
+			cfg:     config.Config{},
+			wantErr: true,
+		},
+		{
+			name:    "ambiguous active key",
+			cfg:     config.Config{JWTKeys: map[string]string{"k1": "a", "k2": "b"}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown active key",
+			cfg:     config.Config{JWTKeys: map[string]string{"k1": "a"}, JWTActiveKey: "k2"},
+			wantErr: true,
+		},
+		{
+			name:    "default key configured twice",
+			cfg:     config.Config{JWTSecret: "a", JWTKeys: map[string]string{LegacyKeyID: "b"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.cfg)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,166 @@
+// Package deletion collects link deletion requests from many users and
+// applies them to storage in batches in the background.
+package deletion
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+const (
+	queueSize     = 1024
+	batchSize     = 100
+	flushInterval = time.Second
+	maxAttempts   = 3
+	retryDelay    = 100 * time.Millisecond
+)
+
+var ErrStopped = errors.New("deletion worker is stopped")
+
+// Store is the part of storage.Storage the worker needs.
+type Store interface {
+	DeleteURLs(ctx context.Context, urls []string, userID string) error
+}
+
+type request struct {
+	userID string
+	urls   []string
+}
+
+type Worker struct {
+	store  Store
+	queue  chan request
+	logger *zap.SugaredLogger
+
+	// mu guards stopped. Enqueue holds the read lock while sending, so once
+	// Run holds the write lock no request can slip into the queue unseen.
+	mu      sync.RWMutex
+	stopped bool
+}
+
+func NewWorker(store Store, logger *zap.SugaredLogger) *Worker {
+	return &Worker{
+		store:  store,
+		queue:  make(chan request, queueSize),
+		logger: logger,
+	}
+}
+
+// Enqueue schedules urls of userID for deletion. It blocks while the queue is
+// full and returns ErrStopped once the worker has shut down.
+func (w *Worker) Enqueue(ctx context.Context, userID string, urls []string) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	if w.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case w.queue <- request{userID: userID, urls: urls}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Pending returns the number of deletion requests waiting in the queue.
+func (w *Worker) Pending() int {
+	return len(w.queue)
+}
+
+// Run applies queued deletions until ctx is done. Before returning it stops
+// accepting new requests and flushes everything that was queued.
+func (w *Worker) Run(ctx context.Context) {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	b := newBatch()
+	for {
+		select {
+		case req := <-w.queue:
+			b.add(req)
+			if b.size >= batchSize {
+				w.flush(b)
+				b = newBatch()
+			}
+		case <-ticker.C:
+			w.flush(b)
+			b = newBatch()
+		case <-ctx.Done():
+			w.drain(b)
+			return
+		}
+	}
+}
+
+func (w *Worker) drain(b *batch) {
+	locked := make(chan struct{})
+	go func() {
+		w.mu.Lock()
+		w.stopped = true
+		close(locked)
+	}()
+
+	// Keep consuming while waiting for the lock, so that blocked Enqueue
+	// calls can finish and release their read locks.
+	for {
+		select {
+		case req := <-w.queue:
+			b.add(req)
+		case <-locked:
+			for len(w.queue) > 0 {
+				b.add(<-w.queue)
+			}
+			w.mu.Unlock()
+			w.flush(b)
+			return
+		}
+	}
+}
+
+func (w *Worker) flush(b *batch) {
+	for userID, urls := range b.urls {
+		if err := w.delete(userID, urls); err != nil {
+			w.logger.Errorw("failed to delete urls", "userID", userID, "count", len(urls), "err", err)
+		}
+	}
+}
+
+// delete retries failed deletions with a growing delay. It does not use the
+// Run context, so pending deletions still go through during shutdown.
+func (w *Worker) delete(userID string, urls []string) error {
+	var err error
+	delay := retryDelay
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = w.store.DeleteURLs(context.Background(), urls, userID); err == nil {
+			return nil
+		}
+
+		if attempt < maxAttempts {
+			w.logger.Warnw("retrying urls deletion", "userID", userID, "attempt", attempt, "err", err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+
+	return err
+}
+
+// batch groups pending urls by user, so each user costs one storage call.
+type batch struct {
+	urls map[string][]string
+	size int
+}
+
+func newBatch() *batch {
+	return &batch{urls: map[string][]string{}}
+}
+
+func (b *batch) add(req request) {
+	b.urls[req.userID] = append(b.urls[req.userID], req.urls...)
+	b.size += len(req.urls)
+}
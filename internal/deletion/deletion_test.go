@@ -0,0 +1,83 @@
+package deletion
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/internal/middleware/logger"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type storeMock struct {
+	mu       sync.Mutex
+	failures int
+	calls    int
+	deleted  map[string][]string
+}
+
+func (s *storeMock) DeleteURLs(ctx context.Context, urls []string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("temporary failure")
+	}
+
+	s.deleted[userID] = append(s.deleted[userID], urls...)
+	return nil
+}
+
+func TestWorkerDrainsOnShutdown(t *testing.T) {
+	store := &storeMock{deleted: map[string][]string{}, failures: 1}
+	l, _ := logger.NewLogger()
+	w := NewWorker(store, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	var wg sync.WaitGroup
+	for _, userID := range []string{"alice", "bob"} {
+		for _, url := range []string{"aaa", "bbb", "ccc"} {
+			wg.Add(1)
+			go func(userID, url string) {
+				defer wg.Done()
+				assert.NoError(t, w.Enqueue(context.Background(), userID, []string{url}))
+			}(userID, url)
+		}
+	}
+	wg.Wait()
+
+	cancel()
+	<-done
+
+	for _, userID := range []string{"alice", "bob"} {
+		urls := store.deleted[userID]
+		sort.Strings(urls)
+		assert.Equal(t, []string{"aaa", "bbb", "ccc"}, urls, userID)
+	}
+
+	err := w.Enqueue(context.Background(), "alice", []string{"ddd"})
+	require.ErrorIs(t, err, ErrStopped)
+}
+
+func TestBatchGroupsByUser(t *testing.T) {
+	b := newBatch()
+	b.add(request{userID: "alice", urls: []string{"aaa"}})
+	b.add(request{userID: "alice", urls: []string{"bbb", "ccc"}})
+	b.add(request{userID: "bob", urls: []string{"ddd"}})
+
+	assert.Equal(t, 4, b.size)
+	assert.Equal(t, map[string][]string{
+		"alice": {"aaa", "bbb", "ccc"},
+		"bob":   {"ddd"},
+	}, b.urls)
+}
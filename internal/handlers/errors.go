@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"shortener/internal/apierror"
+	"shortener/internal/models"
+)
+
+// writeErr answers with err when it is a *models.Error and with a generic
+// error built from code and err's message otherwise.
+func writeErr(w http.ResponseWriter, r *http.Request, status int, code string, err error) {
+	var e *models.Error
+	if errors.As(err, &e) {
+		apierror.Write(w, r, status, *e)
+		return
+	}
+
+	apierror.Write(w, r, status, models.Error{Code: code, Message: err.Error()})
+}
+
+func badJSON(w http.ResponseWriter, r *http.Request, err error) {
+	apierror.Write(w, r, http.StatusBadRequest, models.Error{
+		Code:    models.ErrCodeInvalidJSON,
+		Message: "invalid JSON body: " + err.Error(),
+	})
+}
+
+func notImplemented(w http.ResponseWriter, r *http.Request, message string) {
+	apierror.Write(w, r, http.StatusNotImplemented, models.Error{Code: models.ErrCodeNotImplemented, Message: message})
+}
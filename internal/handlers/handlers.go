@@ -5,39 +5,58 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"shortener/config"
+	"shortener/internal/account"
+	"shortener/internal/analytics"
+	"shortener/internal/apierror"
 	"shortener/internal/auth"
+	"shortener/internal/deletion"
 	"shortener/internal/models"
 	"shortener/internal/short"
 	"shortener/internal/storage"
-	"shortener/internal/storage/db"
+	"shortener/internal/validate"
+	"time"
 )
 
-func CreateShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, store storage.Storage, logger *zap.SugaredLogger) {
+func CreateShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, allocator *short.Allocator, policy *validate.Policy, logger *zap.SugaredLogger) {
 	rCtx := r.Context()
 	userID := rCtx.Value(auth.UserIDContextKey)
 	statusCode := http.StatusCreated
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeBadRequest, Message: "couldn't read request body"})
 		logger.Errorw("Couldn't parse url", "error", err)
+		return
 	}
 
 	if _, err := url.ParseRequestURI(string(body)); err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidURL, Message: "provided url is not valid"})
 		logger.Errorw("Provided url is not valid", "error", err)
 		return
 	}
 
-	hash := short.URL(body)
-	err = store.Put(ctx, hash, string(body), userID.(string))
-	alreadySaved := errors.Is(err, db.ErrorConflict)
+	originalURL, err := short.Canonicalize(string(body), cfg)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidURL, Message: err.Error()})
+		logger.Infow("Couldn't canonicalize url", "error", err)
+		return
+	}
+
+	if err := policy.Check(ctx, originalURL); err != nil {
+		rejectURL(w, r, "", err, logger)
+		return
+	}
+
+	hash, err := allocator.Allocate(ctx, originalURL, userID.(string), nil)
+	alreadySaved := errors.Is(err, storage.ErrConflict)
 	if err != nil && !alreadySaved {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("Couldn't write url to storage", "error", err)
 		return
 	}
@@ -48,7 +67,7 @@ func CreateShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request,
 
 	shortURL, err := url.JoinPath(cfg.BaseURL, hash)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("Couldn't write url to storage", "error", err)
 		return
 	}
@@ -57,35 +76,70 @@ func CreateShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request,
 	w.WriteHeader(statusCode)
 	_, err = w.Write([]byte(shortURL))
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		logger.Errorw("CreateShortURL Handler response", "error", err)
 		return
 	}
 }
 
-func Shorten(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, store storage.Storage, logger *zap.SugaredLogger) {
+func Shorten(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, allocator *short.Allocator, policy *validate.Policy, logger *zap.SugaredLogger) {
 	var req models.Request
 	statusCode := http.StatusCreated
 	rCtx := r.Context()
 	userID := rCtx.Value(auth.UserIDContextKey)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		logger.Errorw("can't decode url", "error", err)
+		badJSON(w, r, err)
+		logger.Infow("can't decode url", "error", err)
 		return
 	}
 
 	if req.URL == "" {
-		http.Error(w, "url should be provided", http.StatusBadRequest)
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidURL, Message: "url should be provided", Field: "url"})
+		return
+	}
+
+	originalURL, err := short.Canonicalize(req.URL, cfg)
+	if err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidURL, Message: err.Error(), Field: "url"})
+		return
+	}
+
+	if err := policy.Check(ctx, originalURL); err != nil {
+		rejectURL(w, r, "url", err, logger)
+		return
+	}
+
+	expiresAt, err := linkExpiry(req.ExpiresAt, req.TTLSeconds, time.Now())
+	if err != nil {
+		writeErr(w, r, http.StatusBadRequest, models.ErrCodeInvalidValue, err)
+		return
+	}
+
+	var hash string
+	if req.Alias != "" {
+		hash, err = allocator.Reserve(ctx, req.Alias, originalURL, userID.(string), expiresAt)
+	} else {
+		hash, err = allocator.Allocate(ctx, originalURL, userID.(string), expiresAt)
+	}
+
+	if errors.Is(err, short.ErrInvalidAlias) {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: err.Error(), Field: "alias"})
 		return
 	}
 
-	hash := short.URL([]byte(req.URL))
+	if errors.Is(err, storage.ErrKeyExists) {
+		apierror.Write(w, r, http.StatusConflict, models.Error{Code: models.ErrCodeAliasTaken, Message: "alias is already taken", Field: "alias"})
+		return
+	}
 
-	err := store.Put(ctx, hash, req.URL, userID.(string))
-	alreadySaved := errors.Is(err, db.ErrorConflict)
+	if errors.Is(err, short.ErrURLShortened) {
+		apierror.Write(w, r, http.StatusConflict, models.Error{Code: models.ErrCodeURLShortened, Message: err.Error(), Field: "alias"})
+		return
+	}
+
+	alreadySaved := errors.Is(err, storage.ErrConflict)
 	if err != nil && !alreadySaved {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("can't save url in db", "error", err)
 		return
 	}
@@ -96,7 +150,7 @@ func Shorten(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg co
 
 	shortURL, err := url.JoinPath(cfg.BaseURL, hash)
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("can't create url", "error", err)
 		return
 	}
@@ -109,28 +163,27 @@ func Shorten(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg co
 	w.WriteHeader(statusCode)
 
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		logger.Errorw("Can't encode url", "error", err)
 		return
 	}
 }
 
-func GetShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, store storage.Storage, logger *zap.SugaredLogger) {
+func GetShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, store storage.Storage, recorder *analytics.Recorder, logger *zap.SugaredLogger) {
 	link, err := store.Get(ctx, id)
-	if err != nil {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		logger.Errorw("Can't find shorten url", "error", err)
+	if errors.Is(err, storage.ErrNotFound) {
+		apierror.Write(w, r, http.StatusNotFound, models.Error{Code: models.ErrCodeNotFound, Message: "Link not found"})
 		return
 	}
 
-	if link.OriginalURL == "" {
-		http.Error(w, "Link not found", http.StatusBadRequest)
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("Can't find shorten url", "error", err)
 		return
 	}
 
 	w.Header().Set("location", link.OriginalURL)
-	if link.IsDeleted {
-		w.WriteHeader(http.StatusGone)
+	if link.IsDeleted || link.IsExpired(time.Now()) {
+		apierror.Write(w, r, http.StatusGone, models.Error{Code: models.ErrCodeGone, Message: "Link is no longer available"})
 		return
 	}
 
@@ -139,48 +192,123 @@ func GetShortURL(ctx context.Context, w http.ResponseWriter, r *http.Request, id
 	w.Header().Set("Content-Type", "text/plain")
 	w.Header().Set("Location", link.OriginalURL)
 	w.WriteHeader(http.StatusTemporaryRedirect)
+
+	recorder.Record(r, link.ShortURL)
 }
 
-func ShortenBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, store storage.Storage, logger *zap.SugaredLogger) {
+func GetURLStats(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, store storage.Storage, logger *zap.SugaredLogger) {
+	userID := r.Context().Value(auth.UserIDContextKey)
+
+	stats, err := store.GetStats(ctx, id, userID.(string))
+	if errors.Is(err, storage.ErrNotFound) {
+		apierror.Write(w, r, http.StatusNotFound, models.Error{Code: models.ErrCodeNotFound, Message: "Link not found"})
+		return
+	}
+
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to get link stats", "err", err)
+		return
+	}
+
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		logger.Errorw("error encoding response", "err", err)
+		return
+	}
+}
+
+// MaxBatchSize is the most urls a single batch request may shorten.
+const MaxBatchSize = 100
+
+func ShortenBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, allocator *short.Allocator, policy *validate.Policy, logger *zap.SugaredLogger) {
 	rCtx := r.Context()
 	userID := rCtx.Value(auth.UserIDContextKey)
 
 	var urls models.BatchRequest
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&urls); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		logger.Errorw("can't decode urls batch", "error", err)
+		badJSON(w, r, err)
+		logger.Infow("can't decode urls batch", "error", err)
 		return
 	}
 
 	if len(urls) == 0 {
-		http.Error(w, "Bad request", http.StatusBadRequest)
-		logger.Errorw("body should contains url")
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeBadRequest, Message: "batch should contain at least one url"})
+		return
+	}
+
+	if len(urls) > MaxBatchSize {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{
+			Code:    models.ErrCodeBadRequest,
+			Message: fmt.Sprintf("batch should contain at most %d urls", MaxBatchSize),
+		})
 		return
 	}
 
 	var dbBatch []models.URLItem
+	originals := make([]string, 0, len(urls))
+	now := time.Now()
 	for _, u := range urls {
+		expiresAt, err := linkExpiry(u.ExpiresAt, u.TTLSeconds, now)
+		if err != nil {
+			writeErr(w, r, http.StatusBadRequest, models.ErrCodeInvalidValue, err)
+			return
+		}
+
+		originalURL, err := short.Canonicalize(u.OriginalURL, cfg)
+		if err != nil {
+			apierror.Write(w, r, http.StatusBadRequest, models.Error{
+				Code:    models.ErrCodeInvalidURL,
+				Message: fmt.Sprintf("correlation_id %s: %v", u.CorrelationID, err),
+				Field:   "original_url",
+			})
+			return
+		}
+
 		item := models.URLItem{
 			CorrelationID: u.CorrelationID,
-			OriginalURL:   u.OriginalURL,
-			ShortURL:      short.URL([]byte(u.OriginalURL)),
+			OriginalURL:   originalURL,
+			ShortURL:      u.Alias,
+			ExpiresAt:     expiresAt,
 		}
 		dbBatch = append(dbBatch, item)
+		originals = append(originals, originalURL)
+	}
+
+	if i, err := policy.CheckAll(ctx, originals); err != nil {
+		rejectURL(w, r, "original_url", fmt.Errorf("correlation_id %s: %w", dbBatch[i].CorrelationID, err), logger)
+		return
+	}
+
+	err := allocator.Batch(ctx, dbBatch, userID.(string))
+	if errors.Is(err, short.ErrInvalidAlias) {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: err.Error(), Field: "alias"})
+		return
+	}
+
+	if errors.Is(err, storage.ErrKeyExists) {
+		apierror.Write(w, r, http.StatusConflict, models.Error{Code: models.ErrCodeAliasTaken, Message: "alias is already taken", Field: "alias"})
+		return
+	}
+
+	if errors.Is(err, short.ErrURLShortened) {
+		apierror.Write(w, r, http.StatusConflict, models.Error{Code: models.ErrCodeURLShortened, Message: err.Error(), Field: "alias"})
+		return
 	}
 
-	err := store.Batch(ctx, dbBatch, userID.(string))
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("Can't save urls in storage", "error", err)
 		return
 	}
 
 	var response models.BatchResponse
 	for _, i := range dbBatch {
-		shortURL, err := url.JoinPath(cfg.BaseURL, short.URL([]byte(i.OriginalURL)))
+		shortURL, err := url.JoinPath(cfg.BaseURL, i.ShortURL)
 		if err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			apierror.Internal(w, r)
 			logger.Errorw("Can't create url", "error", err)
 			return
 		}
@@ -197,15 +325,65 @@ func ShortenBatch(ctx context.Context, w http.ResponseWriter, r *http.Request, c
 	w.WriteHeader(http.StatusCreated)
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(response); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		logger.Errorw("Can't encode url", "error", err)
 		return
 	}
 }
 
+// rejectURL answers 422 for urls refused by the validation policy and 500
+// for anything else.
+func rejectURL(w http.ResponseWriter, r *http.Request, field string, err error, logger *zap.SugaredLogger) {
+	var rejected *validate.Error
+	if errors.As(err, &rejected) {
+		apierror.Write(w, r, http.StatusUnprocessableEntity, models.Error{Code: models.ErrCodeURLRejected, Message: err.Error(), Field: field})
+		return
+	}
+
+	apierror.Internal(w, r)
+	logger.Errorw("failed to validate url", "error", err)
+}
+
+// maxTTLSeconds keeps the TTL within what time.Duration can represent.
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+// linkExpiry resolves the optional expiry of a new link from either an
+// absolute time or a TTL relative to now.
+func linkExpiry(expiresAt *time.Time, ttlSeconds int64, now time.Time) (*time.Time, error) {
+	if expiresAt != nil && ttlSeconds != 0 {
+		return nil, &models.Error{
+			Code:    models.ErrCodeInvalidValue,
+			Message: "only one of expires_at and ttl_seconds can be provided",
+			Field:   "ttl_seconds",
+		}
+	}
+
+	if ttlSeconds < 0 || ttlSeconds > maxTTLSeconds {
+		return nil, &models.Error{
+			Code:    models.ErrCodeInvalidValue,
+			Message: fmt.Sprintf("ttl_seconds should be between 1 and %d", maxTTLSeconds),
+			Field:   "ttl_seconds",
+		}
+	}
+
+	if ttlSeconds > 0 {
+		t := now.Add(time.Duration(ttlSeconds) * time.Second)
+		return &t, nil
+	}
+
+	if expiresAt != nil && !expiresAt.After(now) {
+		return nil, &models.Error{
+			Code:    models.ErrCodeInvalidValue,
+			Message: "expires_at should be in the future",
+			Field:   "expires_at",
+		}
+	}
+
+	return expiresAt, nil
+}
+
 func PingDB(w http.ResponseWriter, r *http.Request, store storage.Storage, logger *zap.SugaredLogger) {
 	if err := store.Ping(r.Context()); err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("Can't ping db", "error", err)
 
 		return
@@ -221,7 +399,7 @@ func GetAllURLs(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg
 	urls, err := store.GetAllURLs(ctx, userID.(string))
 
 	if err != nil {
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		apierror.Internal(w, r)
 		logger.Errorw("failed to get user urls", "err", err)
 		return
 	}
@@ -234,7 +412,7 @@ func GetAllURLs(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg
 		for _, u := range urls {
 			shortURL, err := url.JoinPath(cfg.BaseURL, u.ShortURL)
 			if err != nil {
-				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				apierror.Internal(w, r)
 				logger.Errorw("Can't create url", "error", err)
 				return
 			}
@@ -253,45 +431,233 @@ func GetAllURLs(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg
 		w.WriteHeader(http.StatusOK)
 		enc := json.NewEncoder(w)
 		if err := enc.Encode(response); err != nil {
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
 			logger.Errorw("error encoding response", "err", err)
 			return
 		}
 	}
 }
 
-func DeleteURLs(ctx context.Context, writer http.ResponseWriter, request *http.Request, cfg config.Config, str storage.Storage, logger *zap.SugaredLogger) {
+func DeleteURLs(ctx context.Context, writer http.ResponseWriter, request *http.Request, cfg config.Config, deleter *deletion.Worker, logger *zap.SugaredLogger) {
 	requestContext := request.Context()
 	userID := requestContext.Value(auth.UserIDContextKey)
 	var req models.DeleteURLsRequest
 
 	dec := json.NewDecoder(request.Body)
 	if err := dec.Decode(&req); err != nil {
-		http.Error(writer, "Internal server error", http.StatusInternalServerError)
-		logger.Errorw("cannot decode request JSON body", "err", err)
+		badJSON(writer, request, err)
+		logger.Infow("cannot decode request JSON body", "err", err)
+		return
+	}
+
+	if err := deleter.Enqueue(requestContext, userID.(string), req); err != nil {
+		apierror.Write(writer, request, http.StatusServiceUnavailable, models.Error{Code: models.ErrCodeUnavailable, Message: "Service unavailable"})
+		logger.Errorw("failed to schedule urls deletion", "err", err)
 		return
 	}
 
 	writer.WriteHeader(http.StatusAccepted)
-	go func() {
-		err := deletingUserUrls(ctx, str, req, userID.(string))
-		if err != nil {
-			http.Error(writer, "Internal server error", http.StatusInternalServerError)
-			logger.Errorw("failed to delete URLs", "err", err)
-			return
-		}
-	}()
 }
 
-func deletingUserUrls(ctx context.Context, store storage.Storage, urls []string, userID string) error {
-	fmt.Println(urls, userID)
-	select {
-	case <-ctx.Done():
-		return fmt.Errorf("deleting was interrupted by context for userID=%s", userID)
-	default:
-		if err := store.DeleteURLs(ctx, urls, userID); err != nil {
-			return fmt.Errorf("failed to delete user urls with userID=%s: %w", userID, err)
-		}
-		return nil
+// IssueToken returns a fresh token for the current identity so that clients
+// can authenticate with a bearer token instead of the cookie.
+func IssueToken(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, keys *auth.Keyring, logger *zap.SugaredLogger) {
+	userID := ctx.Value(auth.UserIDContextKey)
+
+	registered, _ := ctx.Value(auth.RegisteredContextKey).(bool)
+	token, expiresAt, err := auth.IssueToken(userID.(string), registered, keys, cfg.AuthTokenTTL)
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to issue token", "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(models.TokenResponse{Token: token, ExpiresAt: expiresAt}); err != nil {
+		logger.Errorw("error encoding response", "err", err)
+	}
+}
+
+// CreateAPIKey creates a key for the current user. The secret is only
+// returned in this response.
+func CreateAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request, store storage.APIKeyStorage, logger *zap.SugaredLogger) {
+	if store == nil {
+		notImplemented(w, r, "API keys are not supported by this storage")
+		return
+	}
+
+	userID := ctx.Value(auth.UserIDContextKey)
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		badJSON(w, r, err)
+		logger.Infow("cannot decode request JSON body", "err", err)
+		return
+	}
+
+	if req.Name == "" {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: "API key name is required", Field: "name"})
+		return
+	}
+
+	if len(req.Scopes) == 0 {
+		req.Scopes = auth.DefaultScopes
+	}
+
+	if err := auth.ValidateScopes(req.Scopes); err != nil {
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: err.Error(), Field: "scopes"})
+		return
+	}
+
+	key, keyHash, err := auth.GenerateAPIKey()
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to generate api key", "err", err)
+		return
+	}
+
+	apiKey := models.APIKey{
+		ID:        uuid.NewString(),
+		UserID:    userID.(string),
+		Name:      req.Name,
+		Scopes:    req.Scopes,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := store.CreateAPIKey(ctx, apiKey, keyHash); err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to save api key", "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(models.APIKeyResponse{APIKey: apiKey, Key: key}); err != nil {
+		logger.Errorw("error encoding response", "err", err)
+	}
+}
+
+func ListAPIKeys(ctx context.Context, w http.ResponseWriter, r *http.Request, store storage.APIKeyStorage, logger *zap.SugaredLogger) {
+	if store == nil {
+		notImplemented(w, r, "API keys are not supported by this storage")
+		return
+	}
+
+	userID := ctx.Value(auth.UserIDContextKey)
+
+	keys, err := store.ListAPIKeys(ctx, userID.(string))
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to get api keys", "err", err)
+		return
+	}
+
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(keys); err != nil {
+		logger.Errorw("error encoding response", "err", err)
+	}
+}
+
+func RevokeAPIKey(ctx context.Context, w http.ResponseWriter, r *http.Request, id string, store storage.APIKeyStorage, logger *zap.SugaredLogger) {
+	if store == nil {
+		notImplemented(w, r, "API keys are not supported by this storage")
+		return
+	}
+
+	userID := ctx.Value(auth.UserIDContextKey)
+
+	// Key ids are UUIDs, so anything else cannot name a key.
+	if _, err := uuid.Parse(id); err != nil {
+		apierror.Write(w, r, http.StatusNotFound, models.Error{Code: models.ErrCodeNotFound, Message: "API key not found"})
+		return
+	}
+
+	err := store.RevokeAPIKey(ctx, id, userID.(string))
+	if errors.Is(err, storage.ErrNotFound) {
+		apierror.Write(w, r, http.StatusNotFound, models.Error{Code: models.ErrCodeNotFound, Message: "API key not found"})
+		return
+	}
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to revoke api key", "err", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Register creates an account, moves the links of the current anonymous
+// identity to it and signs the client in.
+func Register(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, accounts *account.Service, keys *auth.Keyring, logger *zap.SugaredLogger) {
+	if accounts == nil {
+		notImplemented(w, r, "Accounts are not supported by this storage")
+		return
+	}
+
+	signIn(ctx, w, r, cfg, keys, logger, accounts.Register, http.StatusCreated)
+}
+
+// Login signs the client in to an existing account and moves the links of
+// the current anonymous identity to it.
+func Login(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, accounts *account.Service, keys *auth.Keyring, logger *zap.SugaredLogger) {
+	if accounts == nil {
+		notImplemented(w, r, "Accounts are not supported by this storage")
+		return
+	}
+
+	signIn(ctx, w, r, cfg, keys, logger, accounts.Login, http.StatusOK)
+}
+
+type signInFunc func(ctx context.Context, creds models.Credentials, anonymousID string) (models.User, error)
+
+func signIn(ctx context.Context, w http.ResponseWriter, r *http.Request, cfg config.Config, keys *auth.Keyring, logger *zap.SugaredLogger, fn signInFunc, statusCode int) {
+	userID := ctx.Value(auth.UserIDContextKey)
+
+	var creds models.Credentials
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		badJSON(w, r, err)
+		logger.Infow("cannot decode request JSON body", "err", err)
+		return
+	}
+
+	user, err := fn(ctx, creds, userID.(string))
+	switch {
+	case errors.Is(err, account.ErrInvalidLogin):
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: err.Error(), Field: "login"})
+		return
+	case errors.Is(err, account.ErrInvalidPassword):
+		apierror.Write(w, r, http.StatusBadRequest, models.Error{Code: models.ErrCodeInvalidValue, Message: err.Error(), Field: "password"})
+		return
+	case errors.Is(err, account.ErrLoginTaken):
+		apierror.Write(w, r, http.StatusConflict, models.Error{Code: models.ErrCodeLoginTaken, Message: err.Error(), Field: "login"})
+		return
+	case errors.Is(err, account.ErrInvalidCredentials):
+		apierror.Write(w, r, http.StatusUnauthorized, models.Error{Code: models.ErrCodeUnauthorized, Message: err.Error()})
+		return
+	case err != nil:
+		apierror.Internal(w, r)
+		logger.Errorw("failed to sign in", "err", err)
+		return
+	}
+
+	token, err := auth.StartSession(w, user.ID, keys, cfg)
+	if err != nil {
+		apierror.Internal(w, r)
+		logger.Errorw("failed to issue token", "err", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(token); err != nil {
+		logger.Errorw("error encoding response", "err", err)
 	}
 }
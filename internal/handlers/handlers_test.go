@@ -5,24 +5,38 @@ import (
 	"context"
 	"encoding/json"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"shortener/config"
+	"shortener/internal/auth"
+	"shortener/internal/logging"
 	"shortener/internal/middleware/logger"
 	"shortener/internal/models"
+	"shortener/internal/short"
 	"shortener/internal/storage"
+	"shortener/internal/validate"
 	"strings"
 	"testing"
+	"time"
 )
 
+func newPolicy(t *testing.T, cfg config.Config) *validate.Policy {
+	policy, err := validate.NewPolicy(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return policy
+}
+
 func TestCreateShortURL(t *testing.T) {
 	cfg := config.Config{
 		ServerAddr:      "localhost:8080",
 		BaseURL:         "http://localhost:8080",
 		FileStoragePath: "",
 	}
-	store, _ := storage.NewStorage(cfg)
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
 
 	tests := []struct {
 		name                string
@@ -51,7 +65,7 @@ func TestCreateShortURL(t *testing.T) {
 			r := httptest.NewRequest(test.method, "/", test.body)
 			w := httptest.NewRecorder()
 			l, _ := logger.NewLogger()
-			CreateShortURL(context.Background(), w, r, cfg, store, l)
+			CreateShortURL(context.Background(), w, r, cfg, short.NewAllocator(store, short.NewMD5(8)), newPolicy(t, cfg), l)
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -71,7 +85,7 @@ func TestCreateShortURLJSON(t *testing.T) {
 		BaseURL:         "http://localhost:8080",
 		FileStoragePath: "",
 	}
-	store, _ := storage.NewStorage(cfg)
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
 
 	tests := []struct {
 		name         string
@@ -94,7 +108,7 @@ func TestCreateShortURLJSON(t *testing.T) {
 			r := httptest.NewRequest(test.method, "/shorten", bytes.NewReader(body))
 			w := httptest.NewRecorder()
 			l, _ := logger.NewLogger()
-			Shorten(context.Background(), w, r, cfg, store, l)
+			Shorten(context.Background(), w, r, cfg, short.NewAllocator(store, short.NewMD5(8)), newPolicy(t, cfg), l)
 
 			res := w.Result()
 			defer res.Body.Close()
@@ -103,3 +117,459 @@ func TestCreateShortURLJSON(t *testing.T) {
 		})
 	}
 }
+
+func TestGetShortURL(t *testing.T) {
+	cfg := config.Config{
+		BaseURL: "http://localhost:8080",
+	}
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
+	expired := time.Now().Add(-time.Minute)
+	_ = store.Put(context.Background(), "0a6383b9", "https://onliner.by", "user", nil)
+	_ = store.Put(context.Background(), "expired1", "https://expired.by", "user", &expired)
+
+	tests := []struct {
+		name             string
+		id               string
+		expectedCode     int
+		expectedLocation string
+	}{
+		{
+			name:             "redirects to the original url",
+			id:               "0a6383b9",
+			expectedCode:     http.StatusTemporaryRedirect,
+			expectedLocation: "https://onliner.by",
+		},
+		{
+			name:             "returns 410 status code if link has expired",
+			id:               "expired1",
+			expectedCode:     http.StatusGone,
+			expectedLocation: "https://expired.by",
+		},
+		{
+			name:         "returns 404 status code if link is not found",
+			id:           "missing1",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/"+test.id, nil)
+			w := httptest.NewRecorder()
+			l, _ := logger.NewLogger()
+			GetShortURL(context.Background(), w, r, test.id, store, nil, l)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			assert.Equal(t, test.expectedLocation, res.Header.Get("Location"))
+		})
+	}
+}
+
+func TestShortenAlias(t *testing.T) {
+	cfg := config.Config{
+		BaseURL: "http://localhost:8080",
+	}
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
+	allocator := short.NewAllocator(store, short.NewMD5(8))
+
+	tests := []struct {
+		name         string
+		body         models.Request
+		expectedCode int
+		expectedBody string
+	}{
+		{
+			name:         "creates link with alias",
+			body:         models.Request{URL: "https://example.com/sale", Alias: "spring-sale"},
+			expectedCode: http.StatusCreated,
+			expectedBody: `{"result":"http://localhost:8080/spring-sale"}`,
+		},
+		{
+			name:         "returns 409 status code if alias is taken",
+			body:         models.Request{URL: "https://example.com/other", Alias: "spring-sale"},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "returns 409 status code if url has another code",
+			body:         models.Request{URL: "https://example.com/sale", Alias: "summer-sale"},
+			expectedCode: http.StatusConflict,
+		},
+		{
+			name:         "returns 400 status code if alias shadows a route",
+			body:         models.Request{URL: "https://example.com/ping", Alias: "ping"},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body, _ := json.Marshal(test.body)
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten", bytes.NewReader(body))
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserIDContextKey, "user"))
+			w := httptest.NewRecorder()
+			l, _ := logger.NewLogger()
+			Shorten(context.Background(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			if test.expectedBody != "" {
+				resBody, _ := io.ReadAll(res.Body)
+				assert.JSONEq(t, test.expectedBody, string(resBody))
+			}
+		})
+	}
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	tests := []struct {
+		name      string
+		expiresAt *time.Time
+		ttl       int64
+		expected  *time.Time
+		wantErr   bool
+	}{
+		{name: "no expiry"},
+		{name: "ttl", ttl: 3600, expected: &future},
+		{name: "absolute time", expiresAt: &future, expected: &future},
+		{name: "both provided", expiresAt: &future, ttl: 60, wantErr: true},
+		{name: "negative ttl", ttl: -1, wantErr: true},
+		{name: "past time", expiresAt: &past, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := linkExpiry(test.expiresAt, test.ttl, now)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, got)
+		})
+	}
+}
+
+func TestIssueToken(t *testing.T) {
+	cfg := config.Config{JWTSecret: "secret", AuthTokenTTL: time.Hour}
+	keys, err := auth.NewKeyring(cfg)
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/auth/token", nil)
+	ctx := context.WithValue(r.Context(), auth.UserIDContextKey, "user")
+	w := httptest.NewRecorder()
+	l, _ := logger.NewLogger()
+	IssueToken(ctx, w, r.WithContext(ctx), cfg, keys, l)
+
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, res.StatusCode)
+
+	var body models.TokenResponse
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.Equal(t, "user", auth.GetUserIDFromJWTToken(body.Token, keys))
+	assert.WithinDuration(t, time.Now().Add(time.Hour), body.ExpiresAt, time.Minute)
+}
+
+type apiKeyStore struct {
+	keys    map[string]models.APIKey
+	revoked []string
+}
+
+func (s *apiKeyStore) CreateAPIKey(ctx context.Context, key models.APIKey, keyHash string) error {
+	s.keys[keyHash] = key
+	return nil
+}
+
+func (s *apiKeyStore) UseAPIKey(ctx context.Context, keyHash string, now time.Time) (models.APIKey, error) {
+	return s.keys[keyHash], nil
+}
+
+func (s *apiKeyStore) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	return nil, nil
+}
+
+func (s *apiKeyStore) RevokeAPIKey(ctx context.Context, id, userID string) error {
+	for hash, key := range s.keys {
+		if key.ID == id && key.UserID == userID {
+			delete(s.keys, hash)
+			s.revoked = append(s.revoked, id)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	const id = "00000000-0000-0000-0000-000000000001"
+
+	tests := []struct {
+		name         string
+		id           string
+		expectedCode int
+	}{
+		{
+			name:         "revokes a key",
+			id:           id,
+			expectedCode: http.StatusNoContent,
+		},
+		{
+			name:         "returns 404 for an unknown key",
+			id:           "00000000-0000-0000-0000-000000000002",
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "returns 404 for an id that is not a uuid",
+			id:           "not-a-uuid",
+			expectedCode: http.StatusNotFound,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := &apiKeyStore{keys: map[string]models.APIKey{"hash": {ID: id, UserID: "user"}}}
+			r := httptest.NewRequest(http.MethodDelete, "/api/user/keys/"+test.id, nil)
+			ctx := context.WithValue(r.Context(), auth.UserIDContextKey, "user")
+			w := httptest.NewRecorder()
+			RevokeAPIKey(ctx, w, r.WithContext(ctx), test.id, store, zap.NewNop().Sugar())
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			if test.expectedCode == http.StatusNoContent {
+				assert.Equal(t, []string{id}, store.revoked)
+			} else {
+				assert.Empty(t, store.revoked)
+			}
+		})
+	}
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	tests := []struct {
+		name         string
+		store        storage.APIKeyStorage
+		body         string
+		expectedCode int
+	}{
+		{
+			name:         "creates a key with default scopes",
+			store:        &apiKeyStore{keys: map[string]models.APIKey{}},
+			body:         `{"name": "ci"}`,
+			expectedCode: http.StatusCreated,
+		},
+		{
+			name:         "rejects unknown scopes",
+			store:        &apiKeyStore{keys: map[string]models.APIKey{}},
+			body:         `{"name": "ci", "scopes": ["admin"]}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "requires a name",
+			store:        &apiKeyStore{keys: map[string]models.APIKey{}},
+			body:         `{}`,
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "returns 501 without key storage",
+			body:         `{"name": "ci"}`,
+			expectedCode: http.StatusNotImplemented,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/user/keys", strings.NewReader(test.body))
+			ctx := context.WithValue(r.Context(), auth.UserIDContextKey, "user")
+			w := httptest.NewRecorder()
+			l, _ := logger.NewLogger()
+			CreateAPIKey(ctx, w, r.WithContext(ctx), test.store, l)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			if test.expectedCode != http.StatusCreated {
+				return
+			}
+
+			var body models.APIKeyResponse
+			assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+			assert.Equal(t, auth.DefaultScopes, body.Scopes)
+
+			stored, err := test.store.UseAPIKey(ctx, auth.HashAPIKey(body.Key), time.Now())
+			assert.NoError(t, err)
+			assert.Equal(t, "user", stored.UserID)
+			assert.Equal(t, body.ID, stored.ID)
+		})
+	}
+}
+
+func TestShortenRejectedURL(t *testing.T) {
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
+	allocator := short.NewAllocator(store, short.NewMD5(8))
+
+	tests := []struct {
+		name         string
+		handler      func(w http.ResponseWriter, r *http.Request)
+		body         string
+		expectedCode int
+	}{
+		{
+			name: "text endpoint",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				l, _ := logger.NewLogger()
+				CreateShortURL(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+			},
+			body:         "javascript:alert(1)",
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "json endpoint",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				l, _ := logger.NewLogger()
+				Shorten(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+			},
+			body:         `{"url": "http://localhost:8080/abc"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "batch endpoint",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				l, _ := logger.NewLogger()
+				ShortenBatch(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+			},
+			body:         `[{"correlation_id": "1", "original_url": "https://example.com"}, {"correlation_id": "2", "original_url": "data:text/html,hi"}]`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "batch too large",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				l, _ := logger.NewLogger()
+				ShortenBatch(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+			},
+			body:         "[" + strings.Repeat(`{"original_url": "https://example.com"},`, MaxBatchSize) + `{"original_url": "https://example.com"}]`,
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserIDContextKey, "user"))
+			w := httptest.NewRecorder()
+			test.handler(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+
+			count, _ := store.Count(context.Background())
+			assert.Zero(t, count)
+		})
+	}
+}
+
+func TestShortenCanonicalURL(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:              "http://localhost:8080",
+		NormalizeCase:        true,
+		NormalizeDefaultPort: true,
+		NormalizePath:        true,
+	}
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
+	allocator := short.NewAllocator(store, short.NewMD5(8))
+	l, _ := logger.NewLogger()
+
+	var results []string
+	for _, body := range []string{"https://Example.com:443", "HTTPS://example.com/"} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r = r.WithContext(context.WithValue(r.Context(), auth.UserIDContextKey, "user"))
+		w := httptest.NewRecorder()
+		CreateShortURL(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+
+		res := w.Result()
+		result, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		results = append(results, string(result))
+	}
+
+	assert.Equal(t, results[0], results[1])
+
+	count, _ := store.Count(context.Background())
+	assert.Equal(t, 1, count)
+}
+
+func TestErrorResponse(t *testing.T) {
+	cfg := config.Config{BaseURL: "http://localhost:8080"}
+	store, _ := storage.NewStorage(cfg, zap.NewNop().Sugar())
+	allocator := short.NewAllocator(store, short.NewMD5(8))
+
+	tests := []struct {
+		name         string
+		body         string
+		accept       string
+		expectedCode int
+		expectedType string
+		expectedBody string
+	}{
+		{
+			name:         "malformed json",
+			body:         `{"url":`,
+			expectedCode: http.StatusBadRequest,
+			expectedType: "application/json",
+			expectedBody: `{"error": {"code": "invalid_json", "message": "invalid JSON body: unexpected EOF", "request_id": "req-1"}}`,
+		},
+		{
+			name:         "field error",
+			body:         `{"url": "https://example.com", "ttl_seconds": -1}`,
+			expectedCode: http.StatusBadRequest,
+			expectedType: "application/json",
+			expectedBody: `{"error": {"code": "invalid_value", "message": "ttl_seconds should be between 1 and 9223372036", "field": "ttl_seconds", "request_id": "req-1"}}`,
+		},
+		{
+			name:         "plain text",
+			body:         `{}`,
+			accept:       "text/plain",
+			expectedCode: http.StatusBadRequest,
+			expectedType: "text/plain; charset=utf-8",
+			expectedBody: "url should be provided (request id req-1)\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(test.body))
+			r = r.WithContext(context.WithValue(r.Context(), auth.UserIDContextKey, "user"))
+			r.Header.Set(logging.RequestIDHeader, "req-1")
+			r.Header.Set("Accept", test.accept)
+			w := httptest.NewRecorder()
+			l, _ := logger.NewLogger()
+			shorten := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				Shorten(r.Context(), w, r, cfg, allocator, newPolicy(t, cfg), l)
+			})
+			logger.WithLogging(shorten, l).ServeHTTP(w, r)
+
+			res := w.Result()
+			defer res.Body.Close()
+			body, _ := io.ReadAll(res.Body)
+
+			assert.Equal(t, test.expectedCode, res.StatusCode)
+			assert.Equal(t, test.expectedType, res.Header.Get("Content-Type"))
+			if test.accept == "" {
+				assert.JSONEq(t, test.expectedBody, string(body))
+			} else {
+				assert.Equal(t, test.expectedBody, string(body))
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Package logging holds what code outside of the logging middleware needs to
+// know about logging: the log formats and the per request values of the
+// access log. It imports nothing else from the service, so any package can
+// use it.
+package logging
+
+import "context"
+
+// Log formats of the server logger.
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+)
+
+// RequestIDHeader carries the ID of a request. An ID sent by the client or a
+// proxy is kept, otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDContextKey contextKey = iota
+	entryContextKey
+)
+
+// entry collects what inner handlers know about the request, such as the
+// user, for the access log line.
+type entry struct {
+	userID string
+}
+
+// NewContext returns ctx carrying requestID and room for the values that
+// SetUserID records.
+func NewContext(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDContextKey, requestID)
+	return context.WithValue(ctx, entryContextKey, &entry{})
+}
+
+// RequestID returns the ID of the request of ctx.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey).(string)
+	return id
+}
+
+// SetUserID records the user a request is made by in its access log line.
+// It does nothing for a ctx that does not come from NewContext.
+func SetUserID(ctx context.Context, userID string) {
+	if e, ok := ctx.Value(entryContextKey).(*entry); ok {
+		e.userID = userID
+	}
+}
+
+// UserID returns what SetUserID recorded for the request of ctx.
+func UserID(ctx context.Context) string {
+	if e, ok := ctx.Value(entryContextKey).(*entry); ok {
+		return e.userID
+	}
+
+	return ""
+}
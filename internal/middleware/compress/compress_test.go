@@ -2,12 +2,15 @@ package compress
 
 import (
 	"context"
+	"go.uber.org/zap"
 	"net/http"
 	"net/http/httptest"
 	"shortener/config"
 	"shortener/internal/handlers"
 	"shortener/internal/middleware/logger"
+	"shortener/internal/short"
 	"shortener/internal/storage"
+	"shortener/internal/validate"
 	"testing"
 )
 
@@ -18,11 +21,12 @@ func TestGzip(t *testing.T) {
 		FileStoragePath: "",
 		JWTSecret:       "test",
 	}
-	storeMock, _ := storage.NewStorage(configMock)
+	storeMock, _ := storage.NewStorage(configMock, zap.NewNop().Sugar())
+	policyMock, _ := validate.NewPolicy(configMock)
 	l, _ := logger.NewLogger()
 
 	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		handlers.Shorten(context.Background(), w, r, configMock, storeMock, l)
+		handlers.Shorten(context.Background(), w, r, configMock, short.NewAllocator(storeMock, short.NewMD5(8)), policyMock, l)
 	})
 
 	handler := Gzip(h, l)
@@ -1,12 +1,19 @@
 package logger
 
 import (
+	"fmt"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 	"log"
+	"net"
 	"net/http"
+	"shortener/internal/logging"
 	"time"
 )
 
+// maxRequestIDLength bounds IDs taken from the request.
+const maxRequestIDLength = 128
+
 type (
 	responseData struct {
 		status int
@@ -20,6 +27,10 @@ type (
 )
 
 func (r *loggingResponseWriter) Write(b []byte) (int, error) {
+	if r.responseData.status == 0 {
+		r.responseData.status = http.StatusOK
+	}
+
 	size, err := r.ResponseWriter.Write(b)
 	r.responseData.size += size
 
@@ -31,6 +42,7 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// NewLogger returns a development logger at debug level.
 func NewLogger() (*zap.SugaredLogger, error) {
 	logger, err := zap.NewDevelopment()
 	if err != nil {
@@ -42,10 +54,49 @@ func NewLogger() (*zap.SugaredLogger, error) {
 	return logger.Sugar(), nil
 }
 
+// New returns a logger writing entries of at least level in the given
+// format, logging.FormatConsole or logging.FormatJSON.
+func New(level, format string) (*zap.SugaredLogger, error) {
+	atomicLevel, err := zap.ParseAtomicLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level: %w", err)
+	}
+
+	var cfg zap.Config
+	switch format {
+	case logging.FormatConsole:
+		cfg = zap.NewDevelopmentConfig()
+		cfg.Development = false
+	case logging.FormatJSON:
+		cfg = zap.NewProductionConfig()
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	cfg.Level = atomicLevel
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, fmt.Errorf("can't initialize zap logger: %w", err)
+	}
+
+	return logger.Sugar(), nil
+}
+
+// WithLogging assigns every request an ID, returns it in
+// logging.RequestIDHeader and writes one access log line once the request is
+// served.
 func WithLogging(h http.Handler, logger *zap.SugaredLogger) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestID := r.Header.Get(logging.RequestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		w.Header().Set(logging.RequestIDHeader, requestID)
+
+		ctx := logging.NewContext(r.Context(), requestID)
+
 		responseData := &responseData{
 			status: 0,
 			size:   0,
@@ -56,21 +107,51 @@ func WithLogging(h http.Handler, logger *zap.SugaredLogger) http.Handler {
 			responseData:   responseData,
 		}
 
-		h.ServeHTTP(&lw, r)
+		h.ServeHTTP(&lw, r.WithContext(ctx))
 
-		duration := time.Since(start)
+		// A handler that writes nothing gets the implicit 200 from
+		// net/http.
+		if responseData.status == 0 {
+			responseData.status = http.StatusOK
+		}
 
 		logger.Infow("Request",
-			"uri", r.RequestURI,
+			"request_id", requestID,
 			"method", r.Method,
-			"duration", duration,
-		)
-
-		logger.Infow("Response",
+			"path", r.URL.Path,
 			"status", responseData.status,
 			"size", responseData.size,
+			"duration", time.Since(start),
+			"user_id", logging.UserID(ctx),
+			"remote_ip", remoteIP(r),
+			"user_agent", r.UserAgent(),
 		)
 	}
 
 	return http.HandlerFunc(logFn)
 }
+
+// validRequestID accepts IDs of printable ASCII so that clients cannot
+// break up log lines.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
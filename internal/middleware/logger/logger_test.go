@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"shortener/internal/logging"
+	"strings"
+	"testing"
+)
+
+func TestWithLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+		keepID    bool
+	}{
+		{name: "generated", requestID: ""},
+		{name: "accepted", requestID: "abc-123", keepID: true},
+		{name: "control characters", requestID: "abc\tdef"},
+		{name: "too long", requestID: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.InfoLevel)
+
+			var seenID string
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seenID = logging.RequestID(r.Context())
+				logging.SetUserID(r.Context(), "user-1")
+				w.WriteHeader(http.StatusTeapot)
+				_, _ = w.Write([]byte("hello"))
+			})
+
+			r := httptest.NewRequest(http.MethodGet, "/abc?q=1", nil)
+			r.Header.Set(logging.RequestIDHeader, test.requestID)
+			r.Header.Set("User-Agent", "test-agent")
+			r.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			WithLogging(h, zap.New(core).Sugar()).ServeHTTP(w, r)
+
+			require.NotEmpty(t, seenID)
+			assert.Equal(t, seenID, w.Header().Get(logging.RequestIDHeader))
+			if test.keepID {
+				assert.Equal(t, test.requestID, seenID)
+			} else {
+				assert.NotEqual(t, test.requestID, seenID)
+			}
+
+			require.Equal(t, 1, logs.Len())
+			fields := logs.All()[0].ContextMap()
+			assert.Equal(t, seenID, fields["request_id"])
+			assert.Equal(t, http.MethodGet, fields["method"])
+			assert.Equal(t, "/abc", fields["path"])
+			assert.EqualValues(t, http.StatusTeapot, fields["status"])
+			assert.EqualValues(t, 5, fields["size"])
+			assert.Contains(t, fields, "duration")
+			assert.Equal(t, "user-1", fields["user_id"])
+			assert.Equal(t, "192.0.2.1", fields["remote_ip"])
+			assert.Equal(t, "test-agent", fields["user_agent"])
+		})
+	}
+}
+
+func TestWithLoggingEmptyResponse(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	WithLogging(h, zap.New(core).Sugar()).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	require.Equal(t, 1, logs.Len())
+	assert.EqualValues(t, http.StatusOK, logs.All()[0].ContextMap()["status"])
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New("debug", "json")
+	assert.NoError(t, err)
+
+	_, err = New("info", "console")
+	assert.NoError(t, err)
+
+	_, err = New("loud", "json")
+	assert.Error(t, err)
+
+	_, err = New("info", "xml")
+	assert.Error(t, err)
+}
@@ -0,0 +1,95 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets of idle clients are dropped.
+const sweepInterval = time.Minute
+
+// Memory is a token bucket limiter kept in process memory. Every key gets a
+// bucket of burst tokens that refills at rate tokens per second. A request
+// costing more than burst is let through on a full bucket and leaves it in
+// debt, so that it is paid for with the time the bucket takes to refill.
+type Memory struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemory returns a limiter allowing rate requests per second with bursts
+// of up to burst requests. rate must be positive.
+func NewMemory(rate float64, burst int) *Memory {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &Memory{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Allow(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: m.burst, last: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = m.refill(b, now)
+	b.last = now
+
+	need := math.Min(float64(n), m.burst)
+	if b.tokens < need {
+		wait := time.Duration((need - b.tokens) / m.rate * float64(time.Second))
+		return false, wait, nil
+	}
+
+	b.tokens -= float64(n)
+
+	return true, 0, nil
+}
+
+func (m *Memory) refill(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*m.rate
+	if tokens > m.burst {
+		return m.burst
+	}
+
+	return tokens
+}
+
+// sweep drops buckets that have refilled completely; they are no different
+// from a new one. The caller must hold the lock.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+
+	for key, b := range m.buckets {
+		if m.refill(b, now) >= m.burst {
+			delete(m.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+// Package ratelimit rejects clients that send requests faster than allowed
+// with 429 Too Many Requests.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"shortener/internal/apierror"
+	"shortener/internal/auth"
+	"shortener/internal/models"
+	"strconv"
+	"time"
+)
+
+// Limiter decides whether the client identified by key may make a request
+// that costs n tokens. When it may not, retryAfter tells when it can try
+// again. The interface leaves room for a backend shared between instances.
+type Limiter interface {
+	Allow(ctx context.Context, key string, n int) (ok bool, retryAfter time.Duration, err error)
+}
+
+// KeyFunc identifies the client a request is counted against.
+type KeyFunc func(r *http.Request) string
+
+// CostFunc tells how many tokens a request takes.
+type CostFunc func(r *http.Request) int
+
+// Unit makes every request take one token.
+func Unit(r *http.Request) int {
+	return 1
+}
+
+// ByUser counts requests per user. Requests that were not authenticated and
+// those of anonymous identities, which a client can get anew at any time,
+// are counted per client IP.
+func ByUser(r *http.Request) string {
+	if anonymous, _ := r.Context().Value(auth.AnonymousContextKey).(bool); anonymous {
+		return ByIP(r)
+	}
+
+	if userID, ok := r.Context().Value(auth.UserIDContextKey).(string); ok && userID != "" {
+		return "user:" + userID
+	}
+
+	return ByIP(r)
+}
+
+// ByIP counts requests per client IP.
+func ByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
+
+// Limit applies limiter to every request. A nil limiter lets everything
+// through, and so does a limiter that fails, so that an outage of a shared
+// backend does not take the service down.
+func Limit(limiter Limiter, key KeyFunc, cost CostFunc, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if limiter == nil {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter, err := limiter.Allow(r.Context(), key(r), cost(r))
+			if err != nil {
+				logger.Errorw("rate limiter failed", "err", err)
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			if !ok {
+				seconds := int(math.Ceil(retryAfter.Seconds()))
+				if seconds < 1 {
+					seconds = 1
+				}
+
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				apierror.Write(w, r, http.StatusTooManyRequests, models.Error{
+					Code:    models.ErrCodeRateLimited,
+					Message: fmt.Sprintf("Too many requests, retry in %d seconds", seconds),
+				})
+				return
+			}
+
+			h.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"shortener/internal/auth"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	m := NewMemory(2, 3)
+	m.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		ok, _, err := m.Allow(ctx, "a", 1)
+		require.NoError(t, err)
+		assert.True(t, ok, "request %d", i)
+	}
+
+	ok, retryAfter, err := m.Allow(ctx, "a", 1)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	ok, _, _ = m.Allow(ctx, "b", 1)
+	assert.True(t, ok, "keys have separate buckets")
+
+	now = now.Add(500 * time.Millisecond)
+	ok, _, _ = m.Allow(ctx, "a", 1)
+	assert.True(t, ok, "a token is refilled")
+
+	now = now.Add(time.Hour)
+	m.Allow(ctx, "c", 1)
+	assert.Len(t, m.buckets, 1, "idle buckets are swept")
+}
+
+func TestMemoryCost(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	m := NewMemory(2, 3)
+	m.now = func() time.Time { return now }
+
+	ok, _, _ := m.Allow(ctx, "a", 2)
+	assert.True(t, ok)
+
+	ok, retryAfter, _ := m.Allow(ctx, "a", 2)
+	assert.False(t, ok, "one token is left")
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	now = now.Add(time.Second)
+	ok, _, _ = m.Allow(ctx, "a", 9)
+	assert.True(t, ok, "a full bucket lets a request larger than the burst through")
+
+	ok, retryAfter, _ = m.Allow(ctx, "a", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 3500*time.Millisecond, retryAfter, "the bucket is in debt")
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(ctx context.Context, key string, n int) (bool, time.Duration, error) {
+	return false, 0, errors.New("backend is down")
+}
+
+func TestLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiter        Limiter
+		key            KeyFunc
+		requests       int
+		wantCode       int
+		wantRetryAfter string
+	}{
+		{
+			name:     "within the limit",
+			limiter:  NewMemory(1, 2),
+			key:      ByIP,
+			requests: 2,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:           "over the limit",
+			limiter:        NewMemory(0.5, 2),
+			key:            ByIP,
+			requests:       3,
+			wantCode:       http.StatusTooManyRequests,
+			wantRetryAfter: "2",
+		},
+		{
+			name:     "disabled",
+			requests: 10,
+			key:      ByIP,
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "failing limiter",
+			limiter:  failingLimiter{},
+			key:      ByIP,
+			requests: 1,
+			wantCode: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Limit(tt.limiter, tt.key, Unit, zap.NewNop().Sugar())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			var w *httptest.ResponseRecorder
+			for i := 0; i < tt.requests; i++ {
+				w = httptest.NewRecorder()
+				h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/shorten", nil))
+			}
+
+			assert.Equal(t, tt.wantCode, w.Code)
+			assert.Equal(t, tt.wantRetryAfter, w.Header().Get("Retry-After"))
+			if tt.wantCode == http.StatusTooManyRequests {
+				assert.Contains(t, w.Body.String(), `"code":"rate_limited"`)
+			}
+		})
+	}
+}
+
+func TestKeys(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "192.0.2.1:1234"
+
+	assert.Equal(t, "ip:192.0.2.1", ByIP(r))
+	assert.Equal(t, "ip:192.0.2.1", ByUser(r))
+
+	r = r.WithContext(context.WithValue(r.Context(), auth.UserIDContextKey, "user"))
+	assert.Equal(t, "user:user", ByUser(r))
+	assert.Equal(t, "ip:192.0.2.1", ByIP(r))
+
+	r = r.WithContext(context.WithValue(r.Context(), auth.AnonymousContextKey, true))
+	assert.Equal(t, "ip:192.0.2.1", ByUser(r), "anonymous identities are counted per IP")
+}
@@ -1,7 +1,12 @@
 package models
 
+import "time"
+
 type Request struct {
-	URL string `json:"url"`
+	URL        string     `json:"url"`
+	Alias      string     `json:"alias,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds int64      `json:"ttl_seconds,omitempty"`
 }
 
 type Response struct {
@@ -9,15 +14,24 @@ type Response struct {
 }
 
 type URLItem struct {
-	CorrelationID string `json:"_,omitempty"`
-	ShortURL      string `json:"short_url"`
-	OriginalURL   string `json:"original_url"`
-	IsDeleted     bool   `json:"is_deleted"`
+	CorrelationID string     `json:"_,omitempty"`
+	ShortURL      string     `json:"short_url"`
+	OriginalURL   string     `json:"original_url"`
+	IsDeleted     bool       `json:"is_deleted"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+}
+
+// IsExpired reports whether the link has an expiry time that is not after now.
+func (i URLItem) IsExpired(now time.Time) bool {
+	return i.ExpiresAt != nil && !i.ExpiresAt.After(now)
 }
 
 type BatchRequest []struct {
-	CorrelationID string `json:"correlation_id"`
-	OriginalURL   string `json:"original_url"`
+	CorrelationID string     `json:"correlation_id"`
+	OriginalURL   string     `json:"original_url"`
+	Alias         string     `json:"alias,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	TTLSeconds    int64      `json:"ttl_seconds,omitempty"`
 }
 
 type BatchResponseItem struct {
@@ -28,3 +42,95 @@ type BatchResponseItem struct {
 type BatchResponse []BatchResponseItem
 
 type DeleteURLsRequest []string
+
+type TokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"-"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+type APIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// APIKeyResponse is returned once when a key is created; Key is not stored.
+type APIKeyResponse struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type User struct {
+	ID        string    `json:"id"`
+	Login     string    `json:"login"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+type Visit struct {
+	ShortURL  string
+	VisitedAt time.Time
+	Referrer  string
+	UserAgent string
+	IPHash    string
+}
+
+type DailyVisits struct {
+	Date   string `json:"date"`
+	Visits int64  `json:"visits"`
+}
+
+type LinkStats struct {
+	ShortURL string        `json:"short_url"`
+	Total    int64         `json:"total"`
+	Daily    []DailyVisits `json:"daily"`
+}
+
+// Error codes let clients tell failures apart without parsing messages.
+const (
+	ErrCodeBadRequest     = "bad_request"
+	ErrCodeInvalidJSON    = "invalid_json"
+	ErrCodeInvalidURL     = "invalid_url"
+	ErrCodeURLRejected    = "url_rejected"
+	ErrCodeInvalidValue   = "invalid_value"
+	ErrCodeAliasTaken     = "alias_taken"
+	ErrCodeURLShortened   = "url_shortened"
+	ErrCodeLoginTaken     = "login_taken"
+	ErrCodeUnauthorized   = "unauthorized"
+	ErrCodeForbidden      = "forbidden"
+	ErrCodeNotFound       = "not_found"
+	ErrCodeGone           = "gone"
+	ErrCodeRateLimited    = "rate_limited"
+	ErrCodeNotImplemented = "not_implemented"
+	ErrCodeUnavailable    = "unavailable"
+	ErrCodeInternal       = "internal_error"
+)
+
+// Error describes why a request failed. Field names the request field at
+// fault, if any.
+type Error struct {
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	Field     string `json:"field,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
@@ -1,54 +1,101 @@
 package server
 
 import (
-	"context"
 	"github.com/go-chi/chi/v5"
 	"go.uber.org/zap"
 	"net/http"
 	"shortener/config"
+	"shortener/internal/account"
+	"shortener/internal/analytics"
+	"shortener/internal/auth"
+	"shortener/internal/deletion"
 	"shortener/internal/handlers"
+	"shortener/internal/short"
 	"shortener/internal/storage"
+	"shortener/internal/validate"
 )
 
 type Handlers struct {
-	config  config.Config
-	storage storage.Storage
-	logger  *zap.SugaredLogger
-	ctx     context.Context
+	config    config.Config
+	storage   storage.Storage
+	allocator *short.Allocator
+	policy    *validate.Policy
+	recorder  *analytics.Recorder
+	deleter   *deletion.Worker
+	keys      *auth.Keyring
+	apiKeys   storage.APIKeyStorage
+	accounts  *account.Service
+	logger    *zap.SugaredLogger
 }
 
-func NewHandlers(ctx context.Context, cfg config.Config, storage storage.Storage, l *zap.SugaredLogger) *Handlers {
+func NewHandlers(cfg config.Config, storage storage.Storage, allocator *short.Allocator, policy *validate.Policy, recorder *analytics.Recorder, deleter *deletion.Worker, keys *auth.Keyring, apiKeys storage.APIKeyStorage, accounts *account.Service, l *zap.SugaredLogger) *Handlers {
 	return &Handlers{
-		config:  cfg,
-		storage: storage,
-		logger:  l,
-		ctx:     ctx,
+		config:    cfg,
+		storage:   storage,
+		allocator: allocator,
+		policy:    policy,
+		recorder:  recorder,
+		deleter:   deleter,
+		keys:      keys,
+		apiKeys:   apiKeys,
+		accounts:  accounts,
+		logger:    l,
 	}
 }
 
 func (h *Handlers) createShortURLHandler(w http.ResponseWriter, r *http.Request) {
-	handlers.CreateShortURL(h.ctx, w, r, h.config, h.storage, h.logger)
+	handlers.CreateShortURL(r.Context(), w, r, h.config, h.allocator, h.policy, h.logger)
 }
 
 func (h *Handlers) shortenHandler(w http.ResponseWriter, r *http.Request) {
-	handlers.Shorten(h.ctx, w, r, h.config, h.storage, h.logger)
+	handlers.Shorten(r.Context(), w, r, h.config, h.allocator, h.policy, h.logger)
 }
 
 func (h *Handlers) getShortURLHandler(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	handlers.GetShortURL(h.ctx, w, r, string(id), h.storage, h.logger)
+	handlers.GetShortURL(r.Context(), w, r, string(id), h.storage, h.recorder, h.logger)
+}
+
+func (h *Handlers) getURLStats(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	handlers.GetURLStats(r.Context(), w, r, id, h.storage, h.logger)
 }
 
 func (h *Handlers) shortenBatchHandler(w http.ResponseWriter, r *http.Request) {
-	handlers.ShortenBatch(h.ctx, w, r, h.config, h.storage, h.logger)
+	handlers.ShortenBatch(r.Context(), w, r, h.config, h.allocator, h.policy, h.logger)
 }
 
 func (h *Handlers) getAllURLs(w http.ResponseWriter, r *http.Request) {
-	handlers.GetAllURLs(h.ctx, w, r, h.config, h.storage, h.logger)
+	handlers.GetAllURLs(r.Context(), w, r, h.config, h.storage, h.logger)
 }
 
 func (h *Handlers) deleteUserURLs(w http.ResponseWriter, r *http.Request) {
-	handlers.DeleteURLs(h.ctx, w, r, h.config, h.storage, h.logger)
+	handlers.DeleteURLs(r.Context(), w, r, h.config, h.deleter, h.logger)
+}
+
+func (h *Handlers) issueToken(w http.ResponseWriter, r *http.Request) {
+	handlers.IssueToken(r.Context(), w, r, h.config, h.keys, h.logger)
+}
+
+func (h *Handlers) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	handlers.CreateAPIKey(r.Context(), w, r, h.apiKeys, h.logger)
+}
+
+func (h *Handlers) listAPIKeys(w http.ResponseWriter, r *http.Request) {
+	handlers.ListAPIKeys(r.Context(), w, r, h.apiKeys, h.logger)
+}
+
+func (h *Handlers) revokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	handlers.RevokeAPIKey(r.Context(), w, r, id, h.apiKeys, h.logger)
+}
+
+func (h *Handlers) register(w http.ResponseWriter, r *http.Request) {
+	handlers.Register(r.Context(), w, r, h.config, h.accounts, h.keys, h.logger)
+}
+
+func (h *Handlers) login(w http.ResponseWriter, r *http.Request) {
+	handlers.Login(r.Context(), w, r, h.config, h.accounts, h.keys, h.logger)
 }
 
 func (h *Handlers) pingDBHandler(w http.ResponseWriter, r *http.Request) {
@@ -1,24 +1,45 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"go.uber.org/zap"
+	"io"
 	"net/http"
 	"shortener/config"
 	"shortener/internal/auth"
 	"shortener/internal/middleware/compress"
 	"shortener/internal/middleware/logger"
+	"shortener/internal/middleware/ratelimit"
 )
 
 type Middleware struct {
-	logger *zap.SugaredLogger
-	cfg    config.Config
+	logger  *zap.SugaredLogger
+	cfg     config.Config
+	keys    *auth.Keyring
+	apiKeys auth.APIKeyStore
+
+	createLimiter   ratelimit.Limiter
+	redirectLimiter ratelimit.Limiter
 }
 
-func NewMiddleware(lg *zap.SugaredLogger, config config.Config) *Middleware {
-	return &Middleware{
-		logger: lg,
-		cfg:    config,
+func NewMiddleware(lg *zap.SugaredLogger, config config.Config, keys *auth.Keyring, apiKeys auth.APIKeyStore) *Middleware {
+	m := &Middleware{
+		logger:  lg,
+		cfg:     config,
+		keys:    keys,
+		apiKeys: apiKeys,
+	}
+
+	if config.CreateRateLimit > 0 {
+		m.createLimiter = ratelimit.NewMemory(config.CreateRateLimit, config.CreateRateBurst)
+	}
+
+	if config.RedirectRateLimit > 0 {
+		m.redirectLimiter = ratelimit.NewMemory(config.RedirectRateLimit, config.RedirectRateBurst)
 	}
+
+	return m
 }
 
 func (m *Middleware) withLogging(h http.Handler) http.Handler {
@@ -30,5 +51,39 @@ func (m *Middleware) withCompressing(h http.Handler) http.Handler {
 }
 
 func (m *Middleware) withAuth(h http.Handler) http.Handler {
-	return auth.WithAuth(h, m.cfg, m.logger)
+	return auth.WithAuth(h, m.keys, m.apiKeys, m.cfg, m.logger)
+}
+
+func (m *Middleware) limitCreate(h http.Handler) http.Handler {
+	return ratelimit.Limit(m.createLimiter, ratelimit.ByUser, ratelimit.Unit, m.logger)(h)
+}
+
+// limitCreateBatch shares the bucket of limitCreate but takes a token for
+// every url of the batch.
+func (m *Middleware) limitCreateBatch(h http.Handler) http.Handler {
+	return ratelimit.Limit(m.createLimiter, ratelimit.ByUser, batchCost, m.logger)(h)
+}
+
+// limitRedirect counts by IP: redirects are followed by anonymous visitors,
+// each of whom would otherwise get a fresh identity and a fresh bucket.
+func (m *Middleware) limitRedirect(h http.Handler) http.Handler {
+	return ratelimit.Limit(m.redirectLimiter, ratelimit.ByIP, ratelimit.Unit, m.logger)(h)
+}
+
+// batchCost counts the items of a JSON array body and puts the body back for
+// the handler. Bodies that are not an array cost one token and are left to
+// the handler to reject.
+func batchCost(r *http.Request) int {
+	body, err := io.ReadAll(r.Body)
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 1
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil || len(items) == 0 {
+		return 1
+	}
+
+	return len(items)
 }
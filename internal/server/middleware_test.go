@@ -0,0 +1,33 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBatchCost(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{name: "batch", body: `[{"original_url": "a"}, {"original_url": "b"}, {"original_url": "c"}]`, want: 3},
+		{name: "empty batch", body: `[]`, want: 1},
+		{name: "not an array", body: `{"url": "a"}`, want: 1},
+		{name: "malformed", body: `[{`, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(tt.body))
+
+			assert.Equal(t, tt.want, batchCost(r))
+
+			body, _ := io.ReadAll(r.Body)
+			assert.Equal(t, tt.body, string(body), "the body is left for the handler")
+		})
+	}
+}
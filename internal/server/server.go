@@ -1,31 +1,197 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"net/http"
+	"os"
+	"os/signal"
+	"shortener/config"
+	"shortener/internal/auth"
+	"sync"
+	"syscall"
+	"time"
 )
 
-func Run(h *Handlers, m *Middleware) error {
+func NewRouter(h *Handlers, m *Middleware) http.Handler {
 	router := chi.NewRouter()
 
 	router.Use(m.withLogging)
 	router.Use(m.withAuth)
 	router.Use(m.withCompressing)
 
-	router.Post("/", h.createShortURLHandler)
-	router.Post("/api/shorten", h.shortenHandler)
-	router.Post("/api/shorten/batch", h.shortenBatchHandler)
-	router.Get("/api/user/urls", h.getAllURLs)
-	router.Delete("/api/user/urls", h.deleteUserURLs)
-	router.Get("/{id}", h.getShortURLHandler)
+	shorten := router.With(auth.RequireScope(auth.ScopeShorten))
+	shorten.With(m.limitCreate).Post("/", h.createShortURLHandler)
+	shorten.With(m.limitCreate).Post("/api/shorten", h.shortenHandler)
+	shorten.With(m.limitCreateBatch).Post("/api/shorten/batch", h.shortenBatchHandler)
+
+	read := router.With(auth.RequireScope(auth.ScopeRead))
+	read.Get("/api/user/urls", h.getAllURLs)
+	read.Get("/api/user/urls/{id}/stats", h.getURLStats)
+
+	router.With(auth.RequireScope(auth.ScopeDelete)).Delete("/api/user/urls", h.deleteUserURLs)
+
+	// A token or a new key carries every scope, so handing them out needs
+	// the keys scope.
+	keys := router.With(auth.RequireScope(auth.ScopeKeys))
+	keys.Post("/api/auth/token", h.issueToken)
+	keys.Post("/api/user/register", h.register)
+	keys.Post("/api/user/login", h.login)
+	keys.Post("/api/user/keys", h.createAPIKey)
+	keys.Get("/api/user/keys", h.listAPIKeys)
+	keys.Delete("/api/user/keys/{id}", h.revokeAPIKey)
+
+	router.With(m.limitRedirect).Get("/{id}", h.getShortURLHandler)
 	router.Get("/ping", h.pingDBHandler)
 
-	m.logger.Infow("Server started at", "address", h.config.ServerAddr)
+	return router
+}
+
+// Run serves requests until the process gets SIGINT or SIGTERM. It then stops
+// accepting connections, waits for in-flight requests and background workers
+// up to the configured timeout and closes the storage. Workers that are late
+// get the timeout once more; storage is only closed once they are done.
+func Run(h *Handlers, m *Middleware) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	err := http.ListenAndServe(h.config.ServerAddr, router)
+	servers, err := newServers(h.config, NewRouter(h, m))
 	if err != nil {
 		return err
 	}
 
-	return nil
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		h.recorder.Run(workersCtx)
+	}()
+	go func() {
+		defer workers.Done()
+		h.deleter.Run(workersCtx)
+	}()
+
+	serveErr := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			m.logger.Infow("Server started at", "address", srv.Addr, "tls", srv.TLSConfig != nil)
+			if srv.TLSConfig != nil {
+				serveErr <- srv.ListenAndServeTLS("", "")
+			} else {
+				serveErr <- srv.ListenAndServe()
+			}
+		}(srv)
+	}
+
+	select {
+	case err = <-serveErr:
+		// A listener failed. The failure is what Run returns, but the other
+		// listeners and the workers still get the full time to drain.
+		m.logger.Errorw("Server failed", "err", err)
+		_ = shutdown(servers, h.config.ShutdownTimeout, stopWorkers, &workers)
+	case <-ctx.Done():
+		m.logger.Infow("Shutting down", "timeout", h.config.ShutdownTimeout)
+		err = shutdown(servers, h.config.ShutdownTimeout, stopWorkers, &workers)
+	}
+
+	// Workers that missed the deadline may still be flushing. They get a
+	// budget of their own, since closing the storage under them would lose
+	// what they are writing.
+	stopWorkers()
+	if !waitFor(&workers, h.config.ShutdownTimeout) {
+		m.logger.Errorw("Background workers did not finish, dropping queued work and leaving storage open",
+			"visits", h.recorder.Pending(),
+			"deletions", h.deleter.Pending(),
+		)
+		return err
+	}
+
+	if closeErr := h.storage.Close(); closeErr != nil {
+		m.logger.Errorw("Failed to close storage", "err", closeErr)
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// newServers returns the main server and, if configured, the plain HTTP
+// server that redirects to it.
+func newServers(cfg config.Config, handler http.Handler) ([]*http.Server, error) {
+	srv := &http.Server{
+		Addr:    cfg.ServerAddr,
+		Handler: handler,
+	}
+
+	if !cfg.TLSEnabled() {
+		return []*http.Server{srv}, nil
+	}
+
+	tlsConfig, challengeHandler, err := newTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv.TLSConfig = tlsConfig
+
+	if cfg.HTTPRedirectAddr == "" {
+		return []*http.Server{srv}, nil
+	}
+
+	redirect := redirectToHTTPS(cfg.ServerAddr)
+	if challengeHandler != nil {
+		redirect = challengeHandler(redirect)
+	}
+
+	return []*http.Server{srv, {Addr: cfg.HTTPRedirectAddr, Handler: redirect}}, nil
+}
+
+func shutdown(servers []*http.Server, timeout time.Duration, stopWorkers context.CancelFunc, workers *sync.WaitGroup) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var err error
+	for _, srv := range servers {
+		if shutdownErr := srv.Shutdown(ctx); shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	// Workers flush what they have queued, including work handed over by the
+	// requests that have just finished.
+	stopWorkers()
+
+	if !waitCtx(ctx, workers) && err == nil {
+		err = fmt.Errorf("background workers did not stop in %v: %w", timeout, ctx.Err())
+	}
+
+	return err
+}
+
+// waitFor reports whether wg is done within timeout.
+func waitFor(wg *sync.WaitGroup, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return waitCtx(ctx, wg)
+}
+
+// waitCtx reports whether wg is done before ctx.
+func waitCtx(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"shortener/config"
+	"shortener/internal/auth"
+	"shortener/internal/short"
+	"shortener/internal/storage"
+	"shortener/internal/validate"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewRouterLimitsBearerTokens(t *testing.T) {
+	cfg := config.Config{
+		BaseURL:         "http://localhost:8080",
+		JWTSecret:       "secret",
+		AuthTokenTTL:    time.Hour,
+		CreateRateLimit: 1,
+		CreateRateBurst: 1,
+	}
+
+	tests := []struct {
+		name       string
+		registered bool
+		wantLimit  bool
+	}{
+		{name: "anonymous identities share the IP bucket", wantLimit: true},
+		{name: "registered users get a bucket each", registered: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lg := zap.NewNop().Sugar()
+			keys, err := auth.NewKeyring(cfg)
+			require.NoError(t, err)
+			store, err := storage.NewStorage(cfg, lg)
+			require.NoError(t, err)
+			policy, err := validate.NewPolicy(cfg)
+			require.NoError(t, err)
+
+			h := NewHandlers(cfg, store, short.NewAllocator(store, short.NewMD5(8)), policy, nil, nil, keys, nil, nil, lg)
+			router := NewRouter(h, NewMiddleware(lg, cfg, keys, nil))
+
+			var created, limited int
+			for i := 0; i < 10; i++ {
+				token, _, err := auth.IssueToken(uuid.NewString(), tt.registered, keys, cfg.AuthTokenTTL)
+				require.NoError(t, err)
+
+				r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(fmt.Sprintf("https://example.com/%d", i)))
+				r.Header.Set("Authorization", "Bearer "+token)
+				r.RemoteAddr = "192.0.2.1:1234"
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+
+				switch w.Code {
+				case http.StatusCreated:
+					created++
+				case http.StatusTooManyRequests:
+					limited++
+				default:
+					t.Fatalf("unexpected status %d: %s", w.Code, w.Body)
+				}
+			}
+
+			if tt.wantLimit {
+				assert.Equal(t, 1, created)
+				assert.Equal(t, 9, limited)
+			} else {
+				assert.Equal(t, 10, created)
+			}
+		})
+	}
+}
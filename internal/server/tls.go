@@ -0,0 +1,123 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"shortener/config"
+	"strings"
+	"time"
+)
+
+// selfSignedValidity is how long a generated development certificate lasts.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// newTLSConfig builds the TLS settings for the configured certificate source.
+// For autocert it also returns the handler that must serve ACME HTTP-01
+// challenges on the plain HTTP listener; otherwise that handler is nil.
+func newTLSConfig(cfg config.Config) (*tls.Config, func(http.Handler) http.Handler, error) {
+	switch {
+	case len(cfg.TLSAutocertDomains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.TLSAutocertDomains...),
+			Cache:      autocert.DirCache(cfg.TLSAutocertCacheDir),
+		}
+		return m.TLSConfig(), m.HTTPHandler, nil
+	case cfg.TLSCertFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	case cfg.TLSSelfSigned:
+		cert, err := selfSignedCert(hosts(cfg))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil, nil
+	}
+
+	return nil, nil, errors.New("TLS is not configured")
+}
+
+// hosts returns the names the service is reachable by.
+func hosts(cfg config.Config) []string {
+	var names []string
+	if u, err := url.Parse(cfg.BaseURL); err == nil && u.Hostname() != "" {
+		names = append(names, u.Hostname())
+	}
+
+	if host, _, err := net.SplitHostPort(cfg.ServerAddr); err == nil && host != "" {
+		names = append(names, host)
+	}
+
+	return append(names, "localhost", "127.0.0.1", "::1")
+}
+
+func selfSignedCert(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"shortener development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same path on the HTTPS
+// listener.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := strings.Trim(r.Host, "[]")
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		target := url.URL{Scheme: "https", Host: host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,73 @@
+package short
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"shortener/internal/storage"
+	"strings"
+	"time"
+)
+
+const (
+	MinAliasLength = 3
+	MaxAliasLength = 64
+)
+
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// ErrURLShortened means the url of an alias already has a different code.
+// It wraps storage.ErrConflict.
+var ErrURLShortened = fmt.Errorf("url is already shortened under another code: %w", storage.ErrConflict)
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// reservedAliases are the first path segments of the service's own routes.
+var reservedAliases = map[string]bool{
+	"api":  true,
+	"ping": true,
+}
+
+// ValidateAlias checks that alias can be served as a short url without
+// shadowing one of the service routes.
+func ValidateAlias(alias string) error {
+	if len(alias) < MinAliasLength || len(alias) > MaxAliasLength {
+		return fmt.Errorf("%w: length must be between %d and %d characters", ErrInvalidAlias, MinAliasLength, MaxAliasLength)
+	}
+
+	if !aliasPattern.MatchString(alias) {
+		return fmt.Errorf("%w: only latin letters, digits, '-' and '_' are allowed", ErrInvalidAlias)
+	}
+
+	if reservedAliases[strings.ToLower(alias)] {
+		return fmt.Errorf("%w: %q is reserved", ErrInvalidAlias, alias)
+	}
+
+	return nil
+}
+
+// Reserve stores url under a custom alias. It returns storage.ErrKeyExists if
+// the alias is taken by another url. If url is already shortened, it returns
+// the existing code together with storage.ErrConflict, or ErrURLShortened
+// when that code is not alias.
+func (a *Allocator) Reserve(ctx context.Context, alias, url, userID string, expiresAt *time.Time) (string, error) {
+	if err := ValidateAlias(alias); err != nil {
+		return "", err
+	}
+
+	err := a.store.Put(ctx, alias, url, userID, expiresAt)
+	if errors.Is(err, storage.ErrConflict) {
+		code, err := a.existing(ctx, url)
+		if code != "" && code != alias {
+			err = fmt.Errorf("%w: %s is already shortened as %s", ErrURLShortened, url, code)
+		}
+		return code, err
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return alias, nil
+}
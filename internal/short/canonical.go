@@ -0,0 +1,177 @@
+package short
+
+import (
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"shortener/config"
+	"sort"
+	"strings"
+)
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// trackingParams are stripped together with every utm_* parameter.
+var trackingParams = map[string]bool{
+	"fbclid": true,
+	"gclid":  true,
+	"yclid":  true,
+	"mc_cid": true,
+	"mc_eid": true,
+}
+
+// Canonicalize rewrites rawURL so that equivalent URLs are stored and hashed
+// the same way. Which rules apply is set by the Normalize* fields of cfg.
+func Canonicalize(rawURL string, cfg config.Config) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse url: %w", err)
+	}
+
+	if u.Host != "" {
+		if err := canonicalHost(u, cfg); err != nil {
+			return "", err
+		}
+	}
+
+	if cfg.NormalizePath {
+		canonicalPath(u)
+	}
+
+	if cfg.NormalizeStripTracking || cfg.NormalizeSortQuery {
+		canonicalQuery(u, cfg)
+	}
+
+	return u.String(), nil
+}
+
+func canonicalHost(u *url.URL, cfg config.Config) error {
+	host, port := u.Hostname(), u.Port()
+
+	if cfg.NormalizeCase {
+		u.Scheme = strings.ToLower(u.Scheme)
+		host = strings.ToLower(host)
+	}
+
+	if cfg.NormalizeIDN && net.ParseIP(host) == nil {
+		ascii, err := idna.Lookup.ToASCII(host)
+		if err != nil {
+			return fmt.Errorf("invalid host %q: %w", host, err)
+		}
+		host = ascii
+	}
+
+	if cfg.NormalizeDefaultPort && port == defaultPorts[strings.ToLower(u.Scheme)] {
+		port = ""
+	}
+
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	return nil
+}
+
+// canonicalPath decodes escaped characters that never need escaping,
+// upper-cases the hex digits of the remaining escapes and gives URLs with a
+// host at least the root path.
+func canonicalPath(u *url.URL) {
+	escaped := u.EscapedPath()
+	if escaped == "" && u.Host != "" {
+		escaped = "/"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) || !isHex(escaped[i+1]) || !isHex(escaped[i+2]) {
+			b.WriteByte(escaped[i])
+			continue
+		}
+
+		c := unhex(escaped[i+1])<<4 | unhex(escaped[i+2])
+		if isUnreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(strings.ToUpper(escaped[i : i+3]))
+		}
+		i += 2
+	}
+
+	path, err := url.PathUnescape(b.String())
+	if err != nil {
+		return
+	}
+
+	u.Path, u.RawPath = path, b.String()
+}
+
+// canonicalQuery works on the raw query so that values keep their encoding.
+func canonicalQuery(u *url.URL, cfg config.Config) {
+	if u.RawQuery == "" {
+		return
+	}
+
+	var params []string
+	for _, param := range strings.Split(u.RawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		if cfg.NormalizeStripTracking && isTracking(queryKey(param)) {
+			continue
+		}
+		params = append(params, param)
+	}
+
+	if cfg.NormalizeSortQuery {
+		sort.SliceStable(params, func(i, j int) bool {
+			return queryKey(params[i]) < queryKey(params[j])
+		})
+	}
+
+	u.RawQuery = strings.Join(params, "&")
+	if u.RawQuery == "" {
+		u.ForceQuery = false
+	}
+}
+
+func queryKey(param string) string {
+	key, _, _ := strings.Cut(param, "=")
+	if unescaped, err := url.QueryUnescape(key); err == nil {
+		return unescaped
+	}
+	return key
+}
+
+func isTracking(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
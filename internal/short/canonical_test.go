@@ -0,0 +1,62 @@
+package short
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/config"
+	"testing"
+)
+
+func TestCanonicalize(t *testing.T) {
+	all := config.Config{
+		NormalizeCase:          true,
+		NormalizeDefaultPort:   true,
+		NormalizeIDN:           true,
+		NormalizePath:          true,
+		NormalizeSortQuery:     true,
+		NormalizeStripTracking: true,
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		cfg     config.Config
+		want    string
+		wantErr bool
+	}{
+		{name: "case", url: "HTTPS://Example.COM/Path", cfg: all, want: "https://example.com/Path"},
+		{name: "http default port", url: "http://example.com:80/a", cfg: all, want: "http://example.com/a"},
+		{name: "https default port", url: "https://example.com:443/a", cfg: all, want: "https://example.com/a"},
+		{name: "other port", url: "https://example.com:8443/a", cfg: all, want: "https://example.com:8443/a"},
+		{name: "ipv6 default port", url: "http://[::1]:80/", cfg: all, want: "http://[::1]/"},
+		{name: "idn", url: "https://Bücher.example/", cfg: all, want: "https://xn--bcher-kva.example/"},
+		{name: "invalid idn", url: "https://a‍b.example/", cfg: all, wantErr: true},
+		{name: "empty path", url: "https://example.com", cfg: all, want: "https://example.com/"},
+		{name: "unreserved escapes", url: "https://example.com/%7Euser/%41b", cfg: all, want: "https://example.com/~user/Ab"},
+		{name: "reserved escapes", url: "https://example.com/a%2fb%3f", cfg: all, want: "https://example.com/a%2Fb%3F"},
+		{name: "sort query", url: "https://example.com/?b=2&a=1&a=0", cfg: all, want: "https://example.com/?a=1&a=0&b=2"},
+		{name: "strip tracking", url: "https://example.com/?utm_source=x&id=1&fbclid=y", cfg: all, want: "https://example.com/?id=1"},
+		{name: "only tracking", url: "https://example.com/?UTM_medium=x", cfg: all, want: "https://example.com/"},
+		{name: "query encoding kept", url: "https://example.com/?q=a+b&x", cfg: all, want: "https://example.com/?q=a+b&x"},
+		{name: "fragment kept", url: "https://example.com/a#Top", cfg: all, want: "https://example.com/a#Top"},
+		{
+			name: "rules disabled",
+			url:  "https://Example.com:443/%7e?utm_source=x&b=1&a=2",
+			want: "https://Example.com:443/%7e?utm_source=x&b=1&a=2",
+		},
+		{name: "unparsable", url: "https://exa mple.com/", cfg: all, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Canonicalize(test.url, test.cfg)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
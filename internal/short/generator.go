@@ -0,0 +1,139 @@
+package short
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"shortener/config"
+	"shortener/internal/storage"
+	"strconv"
+)
+
+const (
+	StrategyMD5        = "md5"
+	StrategyRandom     = "random"
+	StrategySequential = "sequential"
+	StrategyHashids    = "hashids"
+)
+
+// MaxLength is the longest generated code, bounded by the hex encoded MD5 sum.
+const MaxLength = md5.Size * 2
+
+const base62Alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Generator produces candidate short codes. attempt is zero for the first
+// candidate of a URL and grows every time the previous candidate was taken.
+type Generator interface {
+	Generate(ctx context.Context, url string, attempt int) (string, error)
+}
+
+// Sequence hands out increasing numbers, none of them twice, also not after
+// a restart or to other instances sharing it. storage.Storage satisfies it.
+type Sequence interface {
+	NextSequence(ctx context.Context) (uint64, error)
+}
+
+// NewGenerator returns the generator selected by cfg.ShortURLStrategy. Counter
+// based strategies number their codes with the sequence of store.
+func NewGenerator(cfg config.Config, store storage.Storage) (Generator, error) {
+	length := cfg.ShortURLLength
+	if length <= 0 || length > MaxLength {
+		return nil, fmt.Errorf("short url length must be between 1 and %d, got %d", MaxLength, length)
+	}
+
+	switch cfg.ShortURLStrategy {
+	case "", StrategyMD5:
+		return NewMD5(length), nil
+	case StrategyRandom:
+		return NewRandom(length), nil
+	case StrategySequential:
+		return NewSequential(length, store), nil
+	case StrategyHashids:
+		return NewHashids(length, cfg.ShortURLSalt, store), nil
+	default:
+		return nil, fmt.Errorf("unknown short url strategy %q", cfg.ShortURLStrategy)
+	}
+}
+
+// MD5 derives the code from the URL hash, so the same URL always gets the
+// same code. Collisions are resolved by salting the hash with the attempt.
+type MD5 struct {
+	length int
+}
+
+func NewMD5(length int) *MD5 {
+	return &MD5{length: length}
+}
+
+func (g *MD5) Generate(ctx context.Context, url string, attempt int) (string, error) {
+	if attempt > 0 {
+		url += "#" + strconv.Itoa(attempt)
+	}
+
+	hash := md5.Sum([]byte(url))
+
+	return hex.EncodeToString(hash[:])[:g.length], nil
+}
+
+// Random returns unguessable base62 codes.
+type Random struct {
+	length int
+}
+
+func NewRandom(length int) *Random {
+	return &Random{length: length}
+}
+
+func (g *Random) Generate(ctx context.Context, url string, attempt int) (string, error) {
+	max := big.NewInt(int64(len(base62Alphabet)))
+	code := make([]byte, g.length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate random code: %w", err)
+		}
+		code[i] = base62Alphabet[n.Int64()]
+	}
+
+	return string(code), nil
+}
+
+// Sequential encodes the numbers of a sequence in base62, left-padded with
+// zeros to the configured length.
+type Sequential struct {
+	length   int
+	sequence Sequence
+}
+
+func NewSequential(length int, sequence Sequence) *Sequential {
+	return &Sequential{length: length, sequence: sequence}
+}
+
+func (g *Sequential) Generate(ctx context.Context, url string, attempt int) (string, error) {
+	n, err := g.sequence.NextSequence(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get next sequence number: %w", err)
+	}
+
+	code := encode(n, base62Alphabet)
+	for len(code) < g.length {
+		code = base62Alphabet[:1] + code
+	}
+
+	return code, nil
+}
+
+func encode(n uint64, alphabet string) string {
+	base := uint64(len(alphabet))
+	var code []byte
+	for {
+		code = append([]byte{alphabet[n%base]}, code...)
+		n /= base
+		if n == 0 {
+			return string(code)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package short
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/config"
+	mapStorage "shortener/internal/storage/map"
+	"strings"
+	"testing"
+)
+
+// counter is a Sequence that starts after n.
+type counter struct {
+	n uint64
+}
+
+func (c *counter) NextSequence(ctx context.Context) (uint64, error) {
+	c.n++
+	return c.n, nil
+}
+
+func TestGenerators(t *testing.T) {
+	tests := []struct {
+		name          string
+		generator     Generator
+		length        int
+		deterministic bool
+	}{
+		{name: "md5", generator: NewMD5(10), length: 10, deterministic: true},
+		{name: "random", generator: NewRandom(12), length: 12},
+		{name: "sequential", generator: NewSequential(6, &counter{}), length: 6},
+		{name: "hashids", generator: NewHashids(8, "salt", &counter{}), length: 8},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for i := 0; i < 1000; i++ {
+				code, err := test.generator.Generate(context.Background(), "https://example.com", 0)
+				require.NoError(t, err)
+				assert.GreaterOrEqual(t, len(code), test.length)
+
+				for _, c := range code {
+					assert.True(t, strings.ContainsRune(base62Alphabet, c), "unexpected character %q in %s", c, code)
+				}
+
+				if !test.deterministic {
+					assert.False(t, seen[code], "duplicate code %s", code)
+				}
+				seen[code] = true
+			}
+
+			if test.deterministic {
+				assert.Len(t, seen, 1)
+			}
+		})
+	}
+}
+
+func TestSequential(t *testing.T) {
+	g := NewSequential(4, &counter{n: 60})
+
+	first, _ := g.Generate(context.Background(), "", 0)
+	second, _ := g.Generate(context.Background(), "", 0)
+
+	assert.Equal(t, "000Z", first)
+	assert.Equal(t, "0010", second)
+}
+
+func TestNewGenerator(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+	_, err = store.NextSequence(ctx)
+	require.NoError(t, err)
+
+	g, err := NewGenerator(config.Config{ShortURLStrategy: StrategySequential, ShortURLLength: 8}, store)
+	require.NoError(t, err)
+
+	code, err := g.Generate(ctx, "https://example.com/next", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "00000002", code, "codes must be numbered by the sequence of the store")
+
+	_, err = NewGenerator(config.Config{ShortURLStrategy: "unknown", ShortURLLength: 8}, store)
+	assert.Error(t, err)
+
+	_, err = NewGenerator(config.Config{ShortURLStrategy: StrategyRandom, ShortURLLength: 0}, store)
+	assert.Error(t, err)
+}
@@ -0,0 +1,73 @@
+package short
+
+import (
+	"context"
+	"fmt"
+)
+
+// Hashids turns the numbers of a sequence into short codes that look random but
+// are still unique. It is Hashids-style: the alphabet is shuffled with the
+// salt and a lottery character like in Hashids, but without separators and
+// guards its codes differ from those of Hashids libraries.
+type Hashids struct {
+	length   int
+	salt     string
+	alphabet string
+	sequence Sequence
+}
+
+func NewHashids(length int, salt string, sequence Sequence) *Hashids {
+	return &Hashids{
+		length:   length,
+		salt:     salt,
+		alphabet: shuffle(base62Alphabet, salt),
+		sequence: sequence,
+	}
+}
+
+func (g *Hashids) Generate(ctx context.Context, url string, attempt int) (string, error) {
+	n, err := g.sequence.NextSequence(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get next sequence number: %w", err)
+	}
+
+	return g.encode(n), nil
+}
+
+func (g *Hashids) encode(n uint64) string {
+	alphabet := g.alphabet
+	lottery := alphabet[n%100%uint64(len(alphabet))]
+
+	buffer := string(lottery) + g.salt + alphabet
+	alphabet = shuffle(alphabet, buffer[:len(alphabet)])
+	code := string(lottery) + encode(n, alphabet)
+
+	half := len(alphabet) / 2
+	for len(code) < g.length {
+		alphabet = shuffle(alphabet, alphabet)
+		code = alphabet[half:] + code + alphabet[:half]
+
+		if excess := len(code) - g.length; excess > 0 {
+			code = code[excess/2 : excess/2+g.length]
+		}
+	}
+
+	return code
+}
+
+// shuffle deterministically permutes alphabet using salt.
+func shuffle(alphabet, salt string) string {
+	if salt == "" {
+		return alphabet
+	}
+
+	result := []byte(alphabet)
+	for i, v, p := len(result)-1, 0, 0; i > 0; i-- {
+		p += int(salt[v])
+		j := (int(salt[v]) + v + p) % i
+		result[i], result[j] = result[j], result[i]
+		v = (v + 1) % len(salt)
+	}
+
+	return string(result)
+}
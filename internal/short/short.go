@@ -1,12 +1,216 @@
 package short
 
 import (
-	"crypto/md5"
-	"encoding/hex"
+	"context"
+	"errors"
+	"fmt"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"time"
 )
 
-func URL(url []byte) string {
-	hash := md5.Sum(url)
+// maxAttempts limits how many candidate codes are tried for a single URL.
+const maxAttempts = 10
 
-	return hex.EncodeToString(hash[:])[:8]
+var ErrNoFreeCode = errors.New("failed to allocate a free short url")
+
+// Allocator saves URLs under short codes that are never shared by two
+// different original URLs.
+type Allocator struct {
+	store     storage.Storage
+	generator Generator
+}
+
+func NewAllocator(store storage.Storage, generator Generator) *Allocator {
+	return &Allocator{store: store, generator: generator}
+}
+
+// Allocate stores url and returns its short code. If url is already stored,
+// the existing code is returned together with storage.ErrConflict.
+func (a *Allocator) Allocate(ctx context.Context, url, userID string, expiresAt *time.Time) (string, error) {
+	// Look the url up first so that strategies backed by a counter do not
+	// burn a code every time a known url is shortened again.
+	item, err := a.store.GetByOriginalURL(ctx, url)
+	if err == nil {
+		return item.ShortURL, storage.ErrConflict
+	}
+
+	if !errors.Is(err, storage.ErrNotFound) {
+		return "", fmt.Errorf("failed to get existing short url: %w", err)
+	}
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		key, err := a.generator.Generate(ctx, url, attempt)
+		if err != nil {
+			return "", err
+		}
+
+		err = a.store.Put(ctx, key, url, userID, expiresAt)
+		switch {
+		case err == nil:
+			return key, nil
+		case errors.Is(err, storage.ErrConflict):
+			return a.existing(ctx, url)
+		case errors.Is(err, storage.ErrKeyExists):
+			continue
+		default:
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("%w for %s", ErrNoFreeCode, url)
+}
+
+// Batch stores urls and fills in their ShortURL fields. Items that already
+// have ShortURL set keep it as a custom alias. It first tries to save the
+// whole batch at once. When that hits an existing url or a code collision,
+// it resolves every item against the store and saves the ones that are left
+// in a single batch again, so either all new links are saved or none are.
+func (a *Allocator) Batch(ctx context.Context, urls []models.URLItem, userID string) error {
+	aliases := make([]bool, len(urls))
+	for i := range urls {
+		if urls[i].ShortURL != "" {
+			if err := ValidateAlias(urls[i].ShortURL); err != nil {
+				return err
+			}
+			aliases[i] = true
+			continue
+		}
+
+		key, err := a.generator.Generate(ctx, urls[i].OriginalURL, 0)
+		if err != nil {
+			return err
+		}
+		urls[i].ShortURL = key
+	}
+
+	err := a.store.Batch(ctx, urls, userID)
+	if !errors.Is(err, storage.ErrConflict) && !errors.Is(err, storage.ErrKeyExists) {
+		return err
+	}
+
+	attempts := make([]int, len(urls))
+	for round := 0; round < maxAttempts; round++ {
+		pending, err := a.resolve(ctx, urls, aliases, attempts)
+		if err != nil {
+			return err
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+
+		batch := make([]models.URLItem, 0, len(pending))
+		for _, i := range pending {
+			batch = append(batch, urls[i])
+		}
+
+		// Someone else may have saved one of the urls or codes since they
+		// were resolved, in which case the next round picks that up.
+		err = a.store.Batch(ctx, batch, userID)
+		if !errors.Is(err, storage.ErrConflict) && !errors.Is(err, storage.ErrKeyExists) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w for the batch", ErrNoFreeCode)
+}
+
+// resolve gives urls that are already stored their existing codes and picks
+// free codes for the others without writing anything. It returns the indexes
+// of the urls that still have to be saved. Repeated urls within the batch
+// share the code of their first occurrence.
+func (a *Allocator) resolve(ctx context.Context, urls []models.URLItem, aliases []bool, attempts []int) ([]int, error) {
+	var pending []int
+	firsts := map[string]int{}
+	keys := map[string]bool{}
+	for i := range urls {
+		url := urls[i].OriginalURL
+
+		code, err := a.firstCode(ctx, urls, firsts, url)
+		if err != nil {
+			return nil, err
+		}
+
+		if code != "" {
+			if aliases[i] && code != urls[i].ShortURL {
+				return nil, fmt.Errorf("%w: %s is already shortened as %s", ErrURLShortened, url, code)
+			}
+			urls[i].ShortURL = code
+			continue
+		}
+
+		for {
+			taken, err := a.taken(ctx, urls[i].ShortURL, url)
+			if err != nil {
+				return nil, err
+			}
+
+			if !taken && !keys[urls[i].ShortURL] {
+				break
+			}
+
+			if aliases[i] {
+				return nil, fmt.Errorf("alias %s: %w", urls[i].ShortURL, storage.ErrKeyExists)
+			}
+
+			attempts[i]++
+			if attempts[i] >= maxAttempts {
+				return nil, fmt.Errorf("%w for %s", ErrNoFreeCode, url)
+			}
+
+			if urls[i].ShortURL, err = a.generator.Generate(ctx, url, attempts[i]); err != nil {
+				return nil, err
+			}
+		}
+
+		firsts[url] = i
+		keys[urls[i].ShortURL] = true
+		pending = append(pending, i)
+	}
+
+	return pending, nil
+}
+
+// firstCode returns the code url already has, either in the store or from
+// an earlier item of the batch, or an empty string if it has none.
+func (a *Allocator) firstCode(ctx context.Context, urls []models.URLItem, firsts map[string]int, url string) (string, error) {
+	if i, ok := firsts[url]; ok {
+		return urls[i].ShortURL, nil
+	}
+
+	item, err := a.store.GetByOriginalURL(ctx, url)
+	if errors.Is(err, storage.ErrNotFound) {
+		return "", nil
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("failed to get existing short url: %w", err)
+	}
+
+	return item.ShortURL, nil
+}
+
+// taken reports whether key belongs to a link that url cannot replace. Only
+// a dead link of url itself can be, since a live one is found by firstCode.
+func (a *Allocator) taken(ctx context.Context, key, url string) (bool, error) {
+	item, err := a.store.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, fmt.Errorf("failed to check short url %s: %w", key, err)
+	}
+
+	return item.OriginalURL != url, nil
+}
+
+func (a *Allocator) existing(ctx context.Context, url string) (string, error) {
+	item, err := a.store.GetByOriginalURL(ctx, url)
+	if err != nil {
+		return "", fmt.Errorf("failed to get existing short url: %w", err)
+	}
+
+	return item.ShortURL, storage.ErrConflict
 }
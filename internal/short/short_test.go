@@ -0,0 +1,171 @@
+package short
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/config"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	mapStorage "shortener/internal/storage/map"
+	"strings"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+
+	// Occupy the plain hash of the url with a different link.
+	const url = "https://example.com/collision"
+	require.NoError(t, store.Put(ctx, mustGenerate(t, url, 0), "https://example.com/squatter", "user", nil))
+
+	a := NewAllocator(store, NewMD5(8))
+
+	key, err := a.Allocate(ctx, url, "user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, mustGenerate(t, url, 1), key)
+
+	item, err := store.Get(ctx, key)
+	require.NoError(t, err)
+	assert.Equal(t, url, item.OriginalURL)
+
+	again, err := a.Allocate(ctx, url, "user", nil)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	assert.Equal(t, key, again)
+}
+
+func TestAllocatorBatch(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+
+	const url = "https://example.com/batch"
+	require.NoError(t, store.Put(ctx, mustGenerate(t, url, 0), "https://example.com/squatter", "user", nil))
+	require.NoError(t, store.Put(ctx, "saved001", "https://example.com/saved", "user", nil))
+
+	urls := []models.URLItem{
+		{CorrelationID: "1", OriginalURL: url},
+		{CorrelationID: "2", OriginalURL: "https://example.com/saved"},
+		{CorrelationID: "3", OriginalURL: "https://example.com/new"},
+	}
+	require.NoError(t, NewAllocator(store, NewMD5(8)).Batch(ctx, urls, "user"))
+
+	assert.Equal(t, mustGenerate(t, url, 1), urls[0].ShortURL)
+	assert.Equal(t, "saved001", urls[1].ShortURL)
+	assert.Equal(t, mustGenerate(t, "https://example.com/new", 0), urls[2].ShortURL)
+}
+
+func TestAllocateSequentialAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+	cfg := config.Config{ShortURLStrategy: StrategySequential, ShortURLLength: 8}
+
+	gen, err := NewGenerator(cfg, store)
+	require.NoError(t, err)
+	a := NewAllocator(store, gen)
+
+	var keys []string
+	for i := 0; i < 2*maxAttempts; i++ {
+		key, err := a.Allocate(ctx, fmt.Sprintf("https://example.com/%d", i), "user", nil)
+		require.NoError(t, err)
+		keys = append(keys, key)
+	}
+
+	// Freed links are replaced, so the store holds fewer links than codes
+	// were handed out.
+	require.NoError(t, store.DeleteURLs(ctx, keys, "user"))
+	for i := range keys {
+		_, err := a.Allocate(ctx, fmt.Sprintf("https://example.com/%d", i), "user", nil)
+		require.NoError(t, err)
+	}
+
+	gen, err = NewGenerator(cfg, store)
+	require.NoError(t, err)
+
+	key, err := NewAllocator(store, gen).Allocate(ctx, "https://example.com/next", "user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "0000000F", key)
+}
+
+func mustGenerate(t *testing.T, url string, attempt int) string {
+	code, err := NewMD5(8).Generate(context.Background(), url, attempt)
+	require.NoError(t, err)
+	return code
+}
+
+func TestReserve(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+	a := NewAllocator(store, NewMD5(8))
+
+	key, err := a.Reserve(ctx, "spring-sale", "https://example.com/sale", "user", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "spring-sale", key)
+
+	_, err = a.Reserve(ctx, "spring-sale", "https://example.com/other", "user", nil)
+	assert.ErrorIs(t, err, storage.ErrKeyExists)
+
+	key, err = a.Reserve(ctx, "spring-sale", "https://example.com/sale", "user", nil)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	assert.NotErrorIs(t, err, ErrURLShortened)
+	assert.Equal(t, "spring-sale", key)
+
+	key, err = a.Reserve(ctx, "summer-sale", "https://example.com/sale", "user", nil)
+	assert.ErrorIs(t, err, ErrURLShortened)
+	assert.Equal(t, "spring-sale", key)
+
+	for _, alias := range []string{"ab", "Ping", "api", "with space", "слово", strings.Repeat("a", MaxAliasLength+1)} {
+		_, err = a.Reserve(ctx, alias, "https://example.com/"+alias, "user", nil)
+		assert.ErrorIs(t, err, ErrInvalidAlias, alias)
+	}
+}
+
+func TestAllocatorBatchAliases(t *testing.T) {
+	ctx := context.Background()
+	store, err := mapStorage.NewStorage()
+	require.NoError(t, err)
+	require.NoError(t, store.Put(ctx, "taken", "https://example.com/taken", "user", nil))
+	a := NewAllocator(store, NewMD5(8))
+
+	urls := []models.URLItem{
+		{OriginalURL: "https://example.com/one", ShortURL: "one"},
+		{OriginalURL: "https://example.com/two"},
+	}
+	require.NoError(t, a.Batch(ctx, urls, "user"))
+	assert.Equal(t, "one", urls[0].ShortURL)
+	assert.Equal(t, mustGenerate(t, "https://example.com/two", 0), urls[1].ShortURL)
+
+	err = a.Batch(ctx, []models.URLItem{{OriginalURL: "https://example.com/three", ShortURL: "taken"}}, "user")
+	assert.ErrorIs(t, err, storage.ErrKeyExists)
+
+	// A taken alias after new urls fails the whole batch, also on the slow
+	// path that the already saved url sends it down.
+	err = a.Batch(ctx, []models.URLItem{
+		{OriginalURL: "https://example.com/one"},
+		{OriginalURL: "https://example.com/four"},
+		{OriginalURL: "https://example.com/five", ShortURL: "five"},
+		{OriginalURL: "https://example.com/six", ShortURL: "taken"},
+	}, "user")
+	assert.ErrorIs(t, err, storage.ErrKeyExists)
+	for _, url := range []string{"https://example.com/four", "https://example.com/five"} {
+		_, err = store.GetByOriginalURL(ctx, url)
+		assert.ErrorIs(t, err, storage.ErrNotFound, url)
+	}
+
+	err = a.Batch(ctx, []models.URLItem{{OriginalURL: "https://example.com/one", ShortURL: "uno"}}, "user")
+	assert.ErrorIs(t, err, ErrURLShortened)
+
+	urls = []models.URLItem{
+		{OriginalURL: "https://example.com/one", ShortURL: "one"},
+		{OriginalURL: "https://example.com/seven"},
+		{OriginalURL: "https://example.com/seven"},
+	}
+	require.NoError(t, a.Batch(ctx, urls, "user"))
+	assert.Equal(t, "one", urls[0].ShortURL)
+	assert.Equal(t, urls[1].ShortURL, urls[2].ShortURL)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"time"
+)
+
+func (s *storage) CreateAPIKey(ctx context.Context, key models.APIKey, keyHash string) error {
+	_, err := s.pool.Exec(
+		ctx,
+		`INSERT INTO api_keys (id, user_id, name, key_hash, scopes, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)`,
+		key.ID, key.UserID, key.Name, keyHash, key.Scopes, key.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+
+	return nil
+}
+
+// lastUsedPrecision is how stale the recorded last use of a key may get.
+const lastUsedPrecision = time.Minute
+
+// UseAPIKey looks an active key up by its hash and records that it was used
+// at now in the same statement. The row is only updated when its last use is
+// older than lastUsedPrecision; the select reads the snapshot from
+// before the update, hence the coalesce.
+func (s *storage) UseAPIKey(ctx context.Context, keyHash string, now time.Time) (models.APIKey, error) {
+	row := s.pool.QueryRow(
+		ctx,
+		`WITH touched AS (
+			UPDATE api_keys SET last_used_at = $2
+			WHERE key_hash = $1 AND revoked_at IS NULL
+			  AND (last_used_at IS NULL OR last_used_at <= $3)
+			RETURNING id, last_used_at
+		 )
+		 SELECT k.id, k.user_id, k.name, k.scopes, k.created_at, coalesce(t.last_used_at, k.last_used_at)
+		 FROM api_keys k LEFT JOIN touched t ON t.id = k.id
+		 WHERE k.key_hash = $1 AND k.revoked_at IS NULL`,
+		keyHash, now, now.Add(-lastUsedPrecision),
+	)
+
+	key, err := scanAPIKey(row)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return key, errs.ErrNotFound
+	}
+	if err != nil {
+		return key, fmt.Errorf("failed to use api key: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *storage) ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error) {
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT id, user_id, name, scopes, created_at, last_used_at FROM api_keys
+		 WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed getting api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+func (s *storage) RevokeAPIKey(ctx context.Context, id, userID string) error {
+	tag, err := s.pool.Exec(
+		ctx,
+		`UPDATE api_keys SET revoked_at = now()
+		 WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		return errs.ErrNotFound
+	}
+
+	return nil
+}
+
+func scanAPIKey(row pgx.Row) (models.APIKey, error) {
+	var key models.APIKey
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Scopes, &key.CreatedAt, &key.LastUsedAt)
+
+	return key, err
+}
@@ -0,0 +1,60 @@
+package db_test
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"os"
+	"shortener/internal/storage"
+	"shortener/internal/storage/db"
+	"shortener/internal/storage/storagetest"
+	"strings"
+	"testing"
+)
+
+// The suites need a disposable Postgres database in TEST_DATABASE_DSN. The go
+// test workflow points it at a postgres service on every push and pull
+// request, and locally make test-db starts the one from docker-compose.yml.
+// The tests do not start a database of their own, since that would make every
+// go test ./... depend on a Docker daemon or on downloading Postgres binaries.
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t, "links")
+	})
+}
+
+func TestAPIKeys(t *testing.T) {
+	storagetest.RunAPIKeys(t, func(t *testing.T) storage.APIKeyStorage {
+		return newTestStorage(t, "api_keys").(storage.APIKeyStorage)
+	})
+}
+
+func TestUsers(t *testing.T) {
+	storagetest.RunUsers(t, func(t *testing.T) storage.Storage {
+		return newTestStorage(t, "links", "users")
+	})
+}
+
+// newTestStorage opens the database from TEST_DATABASE_DSN with tables
+// emptied and closes it when the test ends.
+func newTestStorage(t *testing.T, tables ...string) storage.Storage {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	s, err := db.NewStorage(dsn, zap.NewNop().Sugar())
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	conn, err := pgx.Connect(context.Background(), dsn)
+	require.NoError(t, err)
+	defer conn.Close(context.Background())
+
+	_, err = conn.Exec(context.Background(), "TRUNCATE "+strings.Join(tables, ", ")+" CASCADE")
+	require.NoError(t, err)
+
+	return s
+}
@@ -9,14 +9,18 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"log"
+	"go.uber.org/zap"
 	"shortener/internal/models"
+	"shortener/internal/storage/errs"
 	"time"
 )
 
 type storage struct {
-	pool *pgxpool.Pool
+	pool        *pgxpool.Pool
+	stopSweeper context.CancelFunc
+	logger      *zap.SugaredLogger
 }
 
 type GetURL struct {
@@ -24,9 +28,12 @@ type GetURL struct {
 	isDeleted   bool
 }
 
-var ErrorConflict = errors.New("url is already saved")
+const uniqueViolationCode = "23505"
 
-func NewStorage(dsn string) (*storage, error) {
+// sweepInterval is how often expired links are marked as deleted.
+const sweepInterval = time.Minute
+
+func NewStorage(dsn string, logger *zap.SugaredLogger) (*storage, error) {
 	if err := runMigrations(dsn); err != nil {
 		return nil, fmt.Errorf("failed to run DB migrations: %w", err)
 	}
@@ -35,9 +42,47 @@ func NewStorage(dsn string) (*storage, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a connection pool: %w", err)
 	}
-	return &storage{
-		pool: pool,
-	}, nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &storage{
+		pool:        pool,
+		stopSweeper: cancel,
+		logger:      logger,
+	}
+	go s.sweep(ctx, sweepInterval)
+
+	return s, nil
+}
+
+// sweep periodically marks expired links as deleted, so they drop out of the
+// same code paths as links removed by their owners.
+func (s *storage) sweep(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := s.expireURLs(ctx, time.Now()); err != nil && ctx.Err() == nil {
+				s.logger.Errorw("failed to mark expired links", "err", err)
+			}
+		}
+	}
+}
+
+func (s *storage) expireURLs(ctx context.Context, now time.Time) (int64, error) {
+	tag, err := s.pool.Exec(
+		ctx,
+		`UPDATE links SET is_deleted = true WHERE expires_at <= $1 AND NOT is_deleted`,
+		now,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update expired links: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
 }
 
 //go:embed migrations/*.sql
@@ -65,31 +110,104 @@ func (s *storage) Get(ctx context.Context, key string) (models.URLItem, error) {
 	var urls models.URLItem
 	row := s.pool.QueryRow(
 		ctx,
-		`SELECT hash_url, original_url, is_deleted FROM links WHERE hash_url = $1`,
+		`SELECT hash_url, original_url, is_deleted, expires_at FROM links WHERE hash_url = $1`,
 		key,
 	)
 
-	if err := row.Scan(&urls.ShortURL, &urls.OriginalURL, &urls.IsDeleted); err != nil {
+	if err := row.Scan(&urls.ShortURL, &urls.OriginalURL, &urls.IsDeleted, &urls.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return urls, errs.ErrNotFound
+		}
 		return urls, fmt.Errorf("failed to read row: %v", err)
 	}
 	return urls, nil
 }
 
-func (s *storage) Put(ctx context.Context, hash string, original string, userID string) error {
-	tag, err := s.pool.Exec(
+// GetByOriginalURL only finds live links: a deleted or expired link no
+// longer holds on to its original URL.
+func (s *storage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLItem, error) {
+	var urls models.URLItem
+	row := s.pool.QueryRow(
 		ctx,
-		`INSERT INTO links (hash_url, original_url, user_id) 
-		 VALUES ($1, $2, $3) ON CONFLICT (original_url) DO NOTHING`,
-		hash, original, userID,
+		`SELECT hash_url, original_url, is_deleted, expires_at FROM links
+		 WHERE original_url = $1 AND NOT is_deleted AND (expires_at IS NULL OR expires_at > $2)`,
+		originalURL, time.Now(),
 	)
+
+	if err := row.Scan(&urls.ShortURL, &urls.OriginalURL, &urls.IsDeleted, &urls.ExpiresAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return urls, errs.ErrNotFound
+		}
+		return urls, fmt.Errorf("failed to read row: %v", err)
+	}
+	return urls, nil
+}
+
+func (s *storage) Put(ctx context.Context, hash string, original string, userID string, expiresAt *time.Time) error {
+	tx, err := s.pool.Begin(ctx)
 	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %v", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := freeOriginalURLs(ctx, tx, []string{original}); err != nil {
+		return err
+	}
+
+	tag, err := tx.Exec(
+		ctx,
+		`INSERT INTO links (hash_url, original_url, user_id, expires_at) 
+		 VALUES ($1, $2, $3, $4) ON CONFLICT (original_url) DO NOTHING`,
+		hash, original, userID, expiresAt,
+	)
+	if err != nil {
+		if uniqueErr := uniqueViolation(err); uniqueErr != nil {
+			return uniqueErr
+		}
 		return fmt.Errorf("failed to insert row: %v", err)
 	}
 
 	count := tag.RowsAffected()
 
 	if count == 0 {
-		return ErrorConflict
+		return errs.ErrConflict
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %v", err)
+	}
+
+	return nil
+}
+
+// freeOriginalURLs removes deleted and expired links of originals, together
+// with their visits, so that the urls can be shortened again.
+func freeOriginalURLs(ctx context.Context, tx pgx.Tx, originals []string) error {
+	_, err := tx.Exec(
+		ctx,
+		`DELETE FROM links WHERE original_url = any($1) AND (is_deleted OR expires_at <= $2)`,
+		originals, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to free original urls: %v", err)
+	}
+
+	return nil
+}
+
+// uniqueViolation translates a unique constraint violation on the links table
+// into the matching storage error, or returns nil for any other error.
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != uniqueViolationCode {
+		return nil
+	}
+
+	switch pgErr.ConstraintName {
+	case "links_original_url_key":
+		return errs.ErrConflict
+	case "links_hash_url_key":
+		return errs.ErrKeyExists
 	}
 
 	return nil
@@ -104,29 +222,44 @@ func (s *storage) Batch(ctx context.Context, rows []models.URLItem, userID strin
 	defer func() {
 		if err != nil {
 			tx.Rollback(ctx)
-			log.Println("transaction rolled back")
+			s.logger.Debugw("transaction rolled back", "err", err)
 		}
 	}()
 
+	originals := make([]string, 0, len(rows))
+	for _, r := range rows {
+		originals = append(originals, r.OriginalURL)
+	}
+	if err = freeOriginalURLs(ctx, tx, originals); err != nil {
+		return err
+	}
+
 	batch := &pgx.Batch{}
 	for _, r := range rows {
 		batch.Queue(
-			`INSERT INTO links (hash_url, original_url, user_id) VALUES ($1, $2, $3)`,
-			r.ShortURL, r.OriginalURL, userID,
+			`INSERT INTO links (hash_url, original_url, user_id, expires_at) VALUES ($1, $2, $3, $4)`,
+			r.ShortURL, r.OriginalURL, userID, r.ExpiresAt,
 		)
 	}
 
-	results := s.pool.SendBatch(ctx, batch)
-	defer results.Close()
-
+	results := tx.SendBatch(ctx, batch)
 	for i := 0; i < batch.Len(); i++ {
-		_, err := results.Exec()
+		_, err = results.Exec()
 		if err != nil {
+			results.Close()
+			if uniqueErr := uniqueViolation(err); uniqueErr != nil {
+				return uniqueErr
+			}
 			return fmt.Errorf("error executing statement %v", err)
 		}
 	}
 
-	err = tx.Commit(context.Background())
+	err = results.Close()
+	if err != nil {
+		return fmt.Errorf("error closing batch results: %v", err)
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
 		return fmt.Errorf("error committing transaction: %v", err)
 	}
@@ -135,7 +268,7 @@ func (s *storage) Batch(ctx context.Context, rows []models.URLItem, userID strin
 }
 
 func (s *storage) GetAllURLs(ctx context.Context, userID string) ([]models.URLItem, error) {
-	rows, err := s.pool.Query(ctx, "SELECT hash_url, original_url FROM links WHERE user_id = $1", userID)
+	rows, err := s.pool.Query(ctx, "SELECT hash_url, original_url, expires_at FROM links WHERE user_id = $1", userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed urls belong to userID=%s: %v", userID, err)
 	}
@@ -145,7 +278,7 @@ func (s *storage) GetAllURLs(ctx context.Context, userID string) ([]models.URLIt
 	for rows.Next() {
 		var row models.URLItem
 
-		err = rows.Scan(&row.ShortURL, &row.OriginalURL)
+		err = rows.Scan(&row.ShortURL, &row.OriginalURL, &row.ExpiresAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan urls %v", err)
 		}
@@ -170,6 +303,33 @@ func (s *storage) DeleteURLs(ctx context.Context, urls []string, userID string)
 	return nil
 }
 
+// NextSequence takes the number from a database sequence, which instances
+// sharing the database draw from together.
+func (s *storage) NextSequence(ctx context.Context) (uint64, error) {
+	var n int64
+	if err := s.pool.QueryRow(ctx, `SELECT nextval('links_code_seq')`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("failed to get next sequence number: %v", err)
+	}
+
+	return uint64(n), nil
+}
+
+func (s *storage) Count(ctx context.Context) (int, error) {
+	var count int
+	if err := s.pool.QueryRow(ctx, `SELECT count(*) FROM links`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count links: %v", err)
+	}
+
+	return count, nil
+}
+
+func (s *storage) Close() error {
+	s.stopSweeper()
+	s.pool.Close()
+
+	return nil
+}
+
 func (s *storage) Ping(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 1*time.Second)
 	defer cancel()
@@ -0,0 +1,25 @@
+DROP INDEX IF EXISTS hash_idx;
+
+-- Before the constraint, two urls could end up with the same code. The link
+-- that is live and oldest keeps it, the others get new codes derived from
+-- their urls, salted with a counter until every code is unique.
+DO $$
+DECLARE
+    attempt integer := 0;
+BEGIN
+    LOOP
+        UPDATE links SET hash_url = left(md5(attempt || ':' || original_url), 8)
+        WHERE ctid IN (
+            SELECT ctid FROM (
+                SELECT ctid, row_number() OVER (PARTITION BY hash_url ORDER BY is_deleted, ctid) AS n
+                FROM links
+            ) AS numbered
+            WHERE n > 1
+        );
+        EXIT WHEN NOT FOUND;
+        attempt := attempt + 1;
+    END LOOP;
+END $$;
+
+ALTER TABLE links
+    ADD CONSTRAINT links_hash_url_key UNIQUE (hash_url);
\ No newline at end of file
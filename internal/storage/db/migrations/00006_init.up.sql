@@ -0,0 +1,2 @@
+ALTER TABLE links
+    ALTER COLUMN hash_url TYPE varchar(64);
\ No newline at end of file
@@ -0,0 +1,4 @@
+ALTER TABLE links
+    ADD COLUMN expires_at timestamptz;
+
+CREATE INDEX links_expires_at_idx ON links (expires_at) WHERE expires_at IS NOT NULL AND NOT is_deleted;
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS visits (
+    id bigserial PRIMARY KEY,
+    hash_url varchar(64) NOT NULL REFERENCES links (hash_url) ON DELETE CASCADE,
+    visited_at timestamptz NOT NULL,
+    referrer text NOT NULL DEFAULT '',
+    user_agent text NOT NULL DEFAULT '',
+    ip_hash varchar(64) NOT NULL DEFAULT ''
+);
+
+CREATE INDEX visits_hash_url_visited_at_idx ON visits (hash_url, visited_at);
\ No newline at end of file
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS users (
+    id varchar(36) PRIMARY KEY,
+    login varchar(64) NOT NULL,
+    password_hash bytea NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    CONSTRAINT users_login_key UNIQUE (login)
+);
+
+CREATE INDEX IF NOT EXISTS links_user_id_idx ON links (user_id);
\ No newline at end of file
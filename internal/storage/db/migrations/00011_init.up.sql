@@ -0,0 +1,7 @@
+-- Counter based short codes are numbered from this sequence, so that numbers
+-- are not handed out again once links are freed, nor twice by instances
+-- sharing the database. Until now the counter restarted from the number of
+-- links, which matched the numbers handed out since links were never removed.
+CREATE SEQUENCE IF NOT EXISTS links_code_seq;
+
+SELECT setval('links_code_seq', (SELECT count(*) FROM links) + 1, false);
\ No newline at end of file
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+)
+
+// CreateUserAndClaim creates the account and moves everything owned by
+// anonymousID to it in one transaction, so that a failed claim leaves no
+// account behind.
+func (s *storage) CreateUserAndClaim(ctx context.Context, user models.User, passwordHash []byte, anonymousID string) error {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO users (id, login, password_hash, created_at) VALUES ($1, $2, $3, $4)`,
+		user.ID, user.Login, passwordHash, user.CreatedAt,
+	)
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode && pgErr.ConstraintName == "users_login_key" {
+		return errs.ErrConflict
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %w", err)
+	}
+
+	if anonymousID != "" {
+		if _, err := claimURLs(ctx, tx, anonymousID, user.ID); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) GetUserByLogin(ctx context.Context, login string) (models.User, []byte, error) {
+	var user models.User
+	var passwordHash []byte
+	err := s.pool.QueryRow(
+		ctx,
+		`SELECT id, login, created_at, password_hash FROM users WHERE login = $1`,
+		login,
+	).Scan(&user.ID, &user.Login, &user.CreatedAt, &passwordHash)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return user, nil, errs.ErrNotFound
+	}
+	if err != nil {
+		return user, nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, passwordHash, nil
+}
+
+// ClaimURLs moves the links and API keys of an anonymous identity to userID.
+// Identities that belong to a registered user are never moved.
+func (s *storage) ClaimURLs(ctx context.Context, anonymousID, userID string) (int64, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("unable to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	n, err := claimURLs(ctx, tx, anonymousID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return n, nil
+}
+
+func claimURLs(ctx context.Context, tx pgx.Tx, anonymousID, userID string) (int64, error) {
+	var registered bool
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM users WHERE id = $1)`, anonymousID).Scan(&registered)
+	if err != nil {
+		return 0, fmt.Errorf("failed to check user: %w", err)
+	}
+
+	if registered {
+		return 0, nil
+	}
+
+	tag, err := tx.Exec(ctx, `UPDATE links SET user_id = $2 WHERE user_id = $1`, anonymousID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move links: %w", err)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE api_keys SET user_id = $2 WHERE user_id = $1`, anonymousID, userID); err != nil {
+		return 0, fmt.Errorf("failed to move api keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"time"
+)
+
+// SaveVisits skips visits of links that no longer exist, since a link can be
+// freed between its redirect and the flush of the visit.
+func (s *storage) SaveVisits(ctx context.Context, visits []models.Visit) error {
+	shortURLs := make([]string, len(visits))
+	visitedAt := make([]time.Time, len(visits))
+	referrers := make([]string, len(visits))
+	userAgents := make([]string, len(visits))
+	ipHashes := make([]string, len(visits))
+	for i, v := range visits {
+		shortURLs[i] = v.ShortURL
+		visitedAt[i] = v.VisitedAt
+		referrers[i] = v.Referrer
+		userAgents[i] = v.UserAgent
+		ipHashes[i] = v.IPHash
+	}
+
+	_, err := s.pool.Exec(
+		ctx,
+		`INSERT INTO visits (hash_url, visited_at, referrer, user_agent, ip_hash)
+		 SELECT v.hash_url, v.visited_at, v.referrer, v.user_agent, v.ip_hash
+		 FROM unnest($1::varchar[], $2::timestamptz[], $3::text[], $4::text[], $5::text[])
+		   AS v (hash_url, visited_at, referrer, user_agent, ip_hash)
+		 WHERE EXISTS (SELECT 1 FROM links l WHERE l.hash_url = v.hash_url)`,
+		shortURLs, visitedAt, referrers, userAgents, ipHashes,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert visits: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) GetStats(ctx context.Context, shortURL, userID string) (models.LinkStats, error) {
+	stats := models.LinkStats{ShortURL: shortURL, Daily: []models.DailyVisits{}}
+
+	var exists int
+	err := s.pool.QueryRow(
+		ctx,
+		`SELECT 1 FROM links WHERE hash_url = $1 AND user_id = $2`,
+		shortURL, userID,
+	).Scan(&exists)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return stats, errs.ErrNotFound
+	}
+	if err != nil {
+		return stats, fmt.Errorf("failed to check link owner: %w", err)
+	}
+
+	rows, err := s.pool.Query(
+		ctx,
+		`SELECT (visited_at AT TIME ZONE 'UTC')::date AS day, count(*)
+		 FROM visits WHERE hash_url = $1 GROUP BY day ORDER BY day`,
+		shortURL,
+	)
+	if err != nil {
+		return stats, fmt.Errorf("failed to query visits: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day time.Time
+		var count int64
+		if err := rows.Scan(&day, &count); err != nil {
+			return stats, fmt.Errorf("failed to scan visits: %w", err)
+		}
+
+		stats.Total += count
+		stats.Daily = append(stats.Daily, models.DailyVisits{Date: day.Format("2006-01-02"), Visits: count})
+	}
+
+	if err := rows.Err(); err != nil {
+		return stats, fmt.Errorf("failed getting visits: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,12 @@
+// Package errs holds the errors shared by all storage backends. It is a leaf
+// package so that backends can return them without importing storage itself;
+// callers should use the aliases exported by the storage package.
+package errs
+
+import "errors"
+
+var (
+	ErrConflict  = errors.New("url is already saved")
+	ErrNotFound  = errors.New("url is not found")
+	ErrKeyExists = errors.New("short url is already taken")
+)
@@ -0,0 +1,18 @@
+package file_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"shortener/internal/storage"
+	"shortener/internal/storage/file"
+	"shortener/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := file.NewStorage(filepath.Join(t.TempDir(), "links.json"))
+		require.NoError(t, err)
+		return s
+	})
+}
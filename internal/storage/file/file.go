@@ -8,134 +8,341 @@ import (
 	"os"
 	"path/filepath"
 	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"shortener/internal/storage/links"
+	"shortener/internal/storage/visits"
+	"sync"
+	"time"
 )
 
+// sequenceBlock is how many numbers NextSequence reserves with one write.
+// What is left of a block is skipped after a restart.
+const sequenceBlock = 100
+
 type storage struct {
-	filePath string
-	numLines int
+	mu         sync.RWMutex
+	file       *os.File
+	visitsFile *os.File
+	links      *links.Index
+	visits     *visits.Counter
+	numLines   int
+	// sequence is the last number NextSequence returned and reserved the
+	// last one written to the file.
+	sequence uint64
+	reserved uint64
 }
 
 type fileLine struct {
-	ShortURL    string `json:"short_url"`
-	OriginalURL string `json:"original_url"`
-	UserID      string `json:"user_id"`
-	IsDeleted   bool   `json:"is_deleted"`
+	ShortURL    string     `json:"short_url"`
+	OriginalURL string     `json:"original_url"`
+	UserID      string     `json:"user_id"`
+	IsDeleted   bool       `json:"is_deleted"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	// Sequence is only set on lines that reserve sequence numbers up to it
+	// and carry no link.
+	Sequence uint64 `json:"sequence,omitempty"`
 }
 
-var increment = 0
-
-func countLines(path string) int {
-	fmt.Println("init increment")
-	count := 0
+func newFileLine(key string, l links.Link) fileLine {
+	return fileLine{
+		ShortURL:    key,
+		OriginalURL: l.OriginalURL,
+		UserID:      l.UserID,
+		IsDeleted:   l.IsDeleted,
+		ExpiresAt:   l.ExpiresAt,
+	}
+}
 
-	file, _ := os.OpenFile(path, os.O_RDONLY, 0666)
-	scanner := bufio.NewScanner(file)
+func (su fileLine) link() links.Link {
+	return links.Link{
+		OriginalURL: su.OriginalURL,
+		UserID:      su.UserID,
+		IsDeleted:   su.IsDeleted,
+		ExpiresAt:   su.ExpiresAt,
+	}
+}
 
+// loadRecords replays the file into the index. Later lines for the same short
+// URL replace earlier ones, which is how deletion tombstones are applied.
+func (s *storage) loadRecords() error {
+	var created uint64
+	scanner := bufio.NewScanner(s.file)
 	for scanner.Scan() {
-		count++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		su := fileLine{}
+		if err := json.Unmarshal(scanner.Bytes(), &su); err != nil {
+			return fmt.Errorf("failed to parse line %d: %w", s.numLines+1, err)
+		}
+
+		s.numLines++
+		if su.Sequence > 0 {
+			s.reserved = su.Sequence
+			continue
+		}
+
+		if !su.IsDeleted {
+			created++
+		}
+		s.index(su)
 	}
 
-	return count
-}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
 
-func (s *storage) Put(ctx context.Context, key, value, userID string) error {
-	file, err := os.OpenFile(s.filePath, os.O_RDWR|os.O_APPEND, 0666)
-	if err != nil {
-		return err
+	// Files from before reservations were written have none, but every
+	// number handed out then was stored as a link.
+	if s.reserved < created {
+		s.reserved = created
 	}
-	defer file.Close()
+	s.sequence = s.reserved
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		su := fileLine{}
+	return nil
+}
 
-		if err := json.Unmarshal(scanner.Bytes(), &su); err != nil {
+// index adds a line to the index, in place of a dead link of the same
+// original URL, and drops the visits of the replaced link. The caller must
+// hold the write lock.
+func (s *storage) index(su fileLine) {
+	if replaced, ok := s.links.Set(su.ShortURL, su.link()); ok {
+		s.visits.Remove(replaced)
+	}
+}
+
+// appendLines writes records to the end of the file in a single write and
+// syncs them to disk. The caller must hold the write lock.
+func (s *storage) appendLines(lines ...fileLine) error {
+	var data []byte
+	for i := range lines {
+		line, err := json.Marshal(&lines[i])
+		if err != nil {
 			return err
 		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
 
-		if su.ShortURL == key {
-			return nil
-		}
+	if _, err := s.file.Write(data); err != nil {
+		return fmt.Errorf("failed to write lines: %w", err)
 	}
 
-	increment++
-	su := fileLine{ShortURL: key, OriginalURL: value, UserID: userID}
-	data, err := json.Marshal(&su)
-	if err != nil {
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
+	s.numLines += len(lines)
+
+	return nil
+}
+
+func (s *storage) Put(ctx context.Context, key, value, userID string, expiresAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.links.Check(key, value, time.Now()); err != nil {
 		return err
 	}
-	data = append(data, '\n')
 
-	_, err = file.Write(data)
-	if err != nil {
+	if err := s.resetVisits(value); err != nil {
 		return err
 	}
 
+	su := fileLine{ShortURL: key, OriginalURL: value, UserID: userID, ExpiresAt: expiresAt}
+	if err := s.appendLines(su); err != nil {
+		return err
+	}
+
+	s.index(su)
+
 	return nil
 }
 
 func (s *storage) Get(ctx context.Context, key string) (models.URLItem, error) {
-	file, err := os.OpenFile(s.filePath, os.O_RDONLY, 0666)
-	if err != nil {
-		return models.URLItem{}, err
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.links.Get(key)
+	if !ok {
+		return models.URLItem{}, errs.ErrNotFound
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		fmt.Println("in get")
-		su := fileLine{}
+	return l.Item(key), nil
+}
 
-		if err := json.Unmarshal(scanner.Bytes(), &su); err != nil {
-			return models.URLItem{}, err
-		}
+func (s *storage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.links.Live(originalURL, time.Now())
+	if !ok {
+		return models.URLItem{}, errs.ErrNotFound
+	}
+
+	l, _ := s.links.Get(key)
 
-		if su.ShortURL == key {
-			return models.URLItem{
-				OriginalURL: su.OriginalURL,
-				ShortURL:    su.ShortURL,
-				IsDeleted:   su.IsDeleted,
-			}, nil
+	return l.Item(key), nil
+}
+
+func (s *storage) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.links.Len(), nil
+}
+
+// NextSequence writes a reservation for the next sequenceBlock numbers
+// whenever the previous one runs out.
+func (s *storage) NextSequence(ctx context.Context) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sequence == s.reserved {
+		reserved := s.reserved + sequenceBlock
+		if err := s.appendLines(fileLine{Sequence: reserved}); err != nil {
+			return 0, fmt.Errorf("failed to reserve sequence numbers: %w", err)
 		}
+		s.reserved = reserved
 	}
 
-	return models.URLItem{}, nil
+	s.sequence++
+
+	return s.sequence, nil
 }
 
 func (s *storage) Ping(ctx context.Context) error {
 	return nil
 }
 
+func (s *storage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.file.Close()
+	if visitsErr := s.visitsFile.Close(); err == nil {
+		err = visitsErr
+	}
+
+	return err
+}
+
+// Batch writes all urls in a single append, so that they are stored in full
+// or not at all.
 func (s *storage) Batch(ctx context.Context, urls []models.URLItem, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.links.CheckBatch(urls, time.Now()); err != nil {
+		return err
+	}
+
+	lines := make([]fileLine, 0, len(urls))
+	originals := make([]string, 0, len(urls))
 	for _, url := range urls {
-		if err := s.Put(ctx, url.ShortURL, url.OriginalURL, userID); err != nil {
-			return err
-		}
+		originals = append(originals, url.OriginalURL)
+		lines = append(lines, fileLine{
+			ShortURL:    url.ShortURL,
+			OriginalURL: url.OriginalURL,
+			UserID:      userID,
+			ExpiresAt:   url.ExpiresAt,
+		})
 	}
+
+	if err := s.resetVisits(originals...); err != nil {
+		return err
+	}
+
+	if err := s.appendLines(lines...); err != nil {
+		return err
+	}
+
+	for _, su := range lines {
+		s.index(su)
+	}
+
 	return nil
 }
 
 func (s *storage) GetAllURLs(ctx context.Context, userID string) ([]models.URLItem, error) {
-	return nil, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.URLItem
+	for _, key := range s.links.UserKeys(userID) {
+		l, _ := s.links.Get(key)
+		urls = append(urls, l.Item(key))
+	}
+
+	return urls, nil
 }
 
+// DeleteURLs marks the user's links as deleted by appending tombstone lines,
+// so the deletion survives a restart without rewriting the file. All
+// tombstones go out in a single write.
 func (s *storage) DeleteURLs(ctx context.Context, shortURLs []string, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lines []fileLine
+	for _, key := range s.links.Deletable(shortURLs, userID) {
+		l, _ := s.links.Get(key)
+		l.IsDeleted = true
+		lines = append(lines, newFileLine(key, l))
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if err := s.appendLines(lines...); err != nil {
+		return fmt.Errorf("failed to delete urls: %w", err)
+	}
+
+	for _, su := range lines {
+		s.index(su)
+	}
+
 	return nil
 }
 
 func NewStorage(path string) (*storage, error) {
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		// Create file if no exists
-		err = os.MkdirAll(filepath.Dir(path), 0666)
-		if err != nil {
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
 			return nil, err
 		}
+	}
 
-		_, err = os.Create(path)
-		if err != nil {
-			return nil, err
-		}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+
+	visitsFile, err := openVisits(path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s := &storage{
+		file:       file,
+		visitsFile: visitsFile,
+		links:      links.NewIndex(),
+		visits:     visits.NewCounter(),
+	}
+
+	if err := s.loadRecords(); err != nil {
+		file.Close()
+		visitsFile.Close()
+		return nil, err
+	}
+
+	if err := s.loadVisits(); err != nil {
+		file.Close()
+		visitsFile.Close()
+		return nil, err
 	}
 
-	return &storage{filePath: path, numLines: countLines(path)}, nil
+	return s, nil
 }
@@ -0,0 +1,147 @@
+package file
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"shortener/internal/models"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorageReloadsIndex(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "db", "links.json")
+
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "abc", "https://example.com", "user", nil))
+	require.NoError(t, s.file.Close())
+
+	reopened, err := NewStorage(path)
+	require.NoError(t, err)
+
+	item, err := reopened.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", item.OriginalURL)
+	assert.Equal(t, 1, reopened.numLines)
+}
+
+func TestStorageConcurrentPut(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage(filepath.Join(t.TempDir(), "links.json"))
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			assert.NoError(t, s.Put(ctx, key, "https://example.com/"+key, "user", nil))
+			_, err := s.Get(ctx, key)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 50, s.numLines)
+}
+
+func TestStorageDeleteURLs(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "aaa", "https://a.example.com", "alice", nil))
+	require.NoError(t, s.Put(ctx, "bbb", "https://b.example.com", "alice", nil))
+	require.NoError(t, s.Put(ctx, "ccc", "https://c.example.com", "bob", nil))
+
+	require.NoError(t, s.DeleteURLs(ctx, []string{"aaa", "ccc", "bbb", "aaa"}, "alice"))
+	assert.Equal(t, 5, s.numLines, "one tombstone per deleted link")
+	require.NoError(t, s.file.Close())
+
+	reopened, err := NewStorage(path)
+	require.NoError(t, err)
+
+	urls, err := reopened.GetAllURLs(ctx, "alice")
+	require.NoError(t, err)
+	require.Len(t, urls, 2)
+	assert.Equal(t, "aaa", urls[0].ShortURL)
+	assert.True(t, urls[0].IsDeleted)
+	assert.True(t, urls[1].IsDeleted)
+
+	item, err := reopened.Get(ctx, "ccc")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted, "bob's link must not be deleted by alice")
+}
+
+func TestStorageReplacedLinkVisitsAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+	visit := models.Visit{ShortURL: "abc", VisitedAt: time.Now()}
+
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "abc", "https://example.com", "alice", nil))
+	require.NoError(t, s.SaveVisits(ctx, []models.Visit{visit, visit}))
+	require.NoError(t, s.DeleteURLs(ctx, []string{"abc"}, "alice"))
+	require.NoError(t, s.Put(ctx, "abc", "https://example.com", "bob", nil))
+	require.NoError(t, s.SaveVisits(ctx, []models.Visit{visit}))
+	require.NoError(t, s.Close())
+
+	reopened, err := NewStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	stats, err := reopened.GetStats(ctx, "abc", "bob")
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, stats.Total, "visits of the replaced link must not come back")
+}
+
+func TestStorageSequenceAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	first, err := s.NextSequence(ctx)
+	require.NoError(t, err)
+	require.NoError(t, s.Put(ctx, "abc", "https://example.com", "user", nil))
+	require.NoError(t, s.Close())
+
+	reopened, err := NewStorage(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	next, err := reopened.NextSequence(ctx)
+	require.NoError(t, err)
+	assert.Greater(t, next, first)
+
+	item, err := reopened.Get(ctx, "abc")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com", item.OriginalURL)
+}
+
+func TestStorageSequenceOfOldFiles(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.json")
+	lines := `{"short_url":"00000001","original_url":"https://a.example.com","user_id":"user","is_deleted":false}
+{"short_url":"00000002","original_url":"https://b.example.com","user_id":"user","is_deleted":false}
+{"short_url":"00000002","original_url":"https://b.example.com","user_id":"user","is_deleted":true}
+`
+	require.NoError(t, os.WriteFile(path, []byte(lines), 0666))
+
+	s, err := NewStorage(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	n, err := s.NextSequence(ctx)
+	require.NoError(t, err)
+	assert.EqualValues(t, 3, n, "numbers of the stored links are not handed out again")
+}
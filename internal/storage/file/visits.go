@@ -0,0 +1,137 @@
+package file
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"time"
+)
+
+// visitsPathSuffix is appended to the links file path to get the visit log.
+const visitsPathSuffix = ".visits"
+
+type visitLine struct {
+	ShortURL  string    `json:"short_url"`
+	VisitedAt time.Time `json:"visited_at"`
+	Referrer  string    `json:"referrer,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	IPHash    string    `json:"ip_hash,omitempty"`
+	// Reset marks the point where the link of ShortURL was replaced. The
+	// visits before it belong to the old link and are dropped on load.
+	Reset bool `json:"reset,omitempty"`
+}
+
+func openVisits(path string) (*os.File, error) {
+	return os.OpenFile(path+visitsPathSuffix, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
+}
+
+func (s *storage) loadVisits() error {
+	scanner := bufio.NewScanner(s.visitsFile)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		vl := visitLine{}
+		if err := json.Unmarshal(scanner.Bytes(), &vl); err != nil {
+			return fmt.Errorf("failed to parse visit line %d: %w", line, err)
+		}
+
+		if vl.Reset {
+			s.visits.Remove(vl.ShortURL)
+			continue
+		}
+
+		s.visits.Add(models.Visit{ShortURL: vl.ShortURL, VisitedAt: vl.VisitedAt})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read visits file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) SaveVisits(ctx context.Context, visits []models.Visit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lines := make([]visitLine, 0, len(visits))
+	for _, v := range visits {
+		lines = append(lines, visitLine{
+			ShortURL:  v.ShortURL,
+			VisitedAt: v.VisitedAt,
+			Referrer:  v.Referrer,
+			UserAgent: v.UserAgent,
+			IPHash:    v.IPHash,
+		})
+	}
+
+	if err := s.appendVisitLines(lines...); err != nil {
+		return err
+	}
+
+	for _, v := range visits {
+		s.visits.Add(v)
+	}
+
+	return nil
+}
+
+// resetVisits logs that the dead links of originalURLs, if there are any,
+// are about to be replaced, so that their visits are not given to the new
+// links after a restart. The caller must hold the write lock.
+func (s *storage) resetVisits(originalURLs ...string) error {
+	var lines []visitLine
+	for _, originalURL := range originalURLs {
+		if key, ok := s.links.Key(originalURL); ok {
+			lines = append(lines, visitLine{ShortURL: key, Reset: true})
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil
+	}
+
+	return s.appendVisitLines(lines...)
+}
+
+// appendVisitLines writes lines to the end of the visit log in a single write
+// and syncs them to disk. The caller must hold the write lock.
+func (s *storage) appendVisitLines(lines ...visitLine) error {
+	var data []byte
+	for i := range lines {
+		line, err := json.Marshal(&lines[i])
+		if err != nil {
+			return err
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	if _, err := s.visitsFile.Write(data); err != nil {
+		return fmt.Errorf("failed to write visits: %w", err)
+	}
+
+	if err := s.visitsFile.Sync(); err != nil {
+		return fmt.Errorf("failed to sync visits file: %w", err)
+	}
+
+	return nil
+}
+
+func (s *storage) GetStats(ctx context.Context, shortURL, userID string) (models.LinkStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.links.Get(shortURL)
+	if !ok || l.UserID != userID {
+		return models.LinkStats{}, errs.ErrNotFound
+	}
+
+	return s.visits.Stats(shortURL), nil
+}
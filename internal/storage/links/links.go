@@ -0,0 +1,172 @@
+// Package links indexes short links for the storage backends that keep them
+// in memory. It enforces what the database does with unique constraints: no
+// two live links share a short or an original URL.
+package links
+
+import (
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"time"
+)
+
+type Link struct {
+	OriginalURL string
+	UserID      string
+	IsDeleted   bool
+	ExpiresAt   *time.Time
+}
+
+func (l Link) Item(key string) models.URLItem {
+	return models.URLItem{
+		OriginalURL: l.OriginalURL,
+		ShortURL:    key,
+		IsDeleted:   l.IsDeleted,
+		ExpiresAt:   l.ExpiresAt,
+	}
+}
+
+// Dead reports whether the link no longer holds on to its original URL.
+func (l Link) Dead(now time.Time) bool {
+	return l.IsDeleted || l.Item("").IsExpired(now)
+}
+
+// Index keeps links by short URL, original URL and user. It is not safe for
+// concurrent use; callers guard it with their own lock.
+type Index struct {
+	links     map[string]Link
+	originals map[string]string
+	userURLs  map[string][]string
+}
+
+func NewIndex() *Index {
+	return &Index{
+		links:     map[string]Link{},
+		originals: map[string]string{},
+		userURLs:  map[string][]string{},
+	}
+}
+
+func (x *Index) Get(key string) (Link, bool) {
+	l, ok := x.links[key]
+	return l, ok
+}
+
+// Len returns the number of links, dead ones included.
+func (x *Index) Len() int {
+	return len(x.links)
+}
+
+// Live returns the key of the live link of originalURL.
+func (x *Index) Live(originalURL string, now time.Time) (string, bool) {
+	key, ok := x.originals[originalURL]
+	if !ok || x.links[key].Dead(now) {
+		return "", false
+	}
+
+	return key, true
+}
+
+// Key returns the key of the link of originalURL, dead or alive. Setting a
+// link that is not deleted for originalURL replaces it.
+func (x *Index) Key(originalURL string) (string, bool) {
+	key, ok := x.originals[originalURL]
+	return key, ok
+}
+
+// UserKeys returns the keys of the links of userID in the order they were
+// added.
+func (x *Index) UserKeys(userID string) []string {
+	return x.userURLs[userID]
+}
+
+// Check reports whether a link can be stored without breaking uniqueness of
+// either the short or the original URL. A dead link of value does not count,
+// and neither does its key.
+func (x *Index) Check(key, value string, now time.Time) error {
+	if _, ok := x.Live(value, now); ok {
+		return errs.ErrConflict
+	}
+
+	if l, ok := x.links[key]; ok && (l.OriginalURL != value || !l.Dead(now)) {
+		return errs.ErrKeyExists
+	}
+
+	return nil
+}
+
+// CheckBatch is Check for a whole batch, which must not repeat a short or an
+// original URL either, so that the batch is stored in full or not at all.
+func (x *Index) CheckBatch(urls []models.URLItem, now time.Time) error {
+	keys := map[string]bool{}
+	originals := map[string]bool{}
+	for _, url := range urls {
+		if err := x.Check(url.ShortURL, url.OriginalURL, now); err != nil {
+			return err
+		}
+
+		if originals[url.OriginalURL] {
+			return errs.ErrConflict
+		}
+
+		if keys[url.ShortURL] {
+			return errs.ErrKeyExists
+		}
+
+		keys[url.ShortURL] = true
+		originals[url.OriginalURL] = true
+	}
+
+	return nil
+}
+
+// Set stores l under key. A link that is not deleted can only have been
+// checked in for an original URL whose link is dead, so it replaces that
+// link, also when it reuses the link's key. The key of the replaced link is
+// returned so that the caller can drop what it keeps about it.
+func (x *Index) Set(key string, l Link) (replaced string, ok bool) {
+	if old, found := x.originals[l.OriginalURL]; found && !l.IsDeleted {
+		x.remove(old)
+		replaced, ok = old, true
+	}
+
+	if _, found := x.links[key]; !found && l.UserID != "" {
+		x.userURLs[l.UserID] = append(x.userURLs[l.UserID], key)
+	}
+
+	x.links[key] = l
+	x.originals[l.OriginalURL] = key
+
+	return replaced, ok
+}
+
+// Deletable returns the keys among keys of links of userID that are not
+// deleted yet, each once.
+func (x *Index) Deletable(keys []string, userID string) []string {
+	var deletable []string
+	seen := map[string]bool{}
+	for _, key := range keys {
+		l, ok := x.links[key]
+		if !ok || l.UserID != userID || l.IsDeleted || seen[key] {
+			continue
+		}
+
+		seen[key] = true
+		deletable = append(deletable, key)
+	}
+
+	return deletable
+}
+
+func (x *Index) remove(key string) {
+	l := x.links[key]
+	delete(x.links, key)
+	delete(x.originals, l.OriginalURL)
+
+	keys := x.userURLs[l.UserID]
+	for i := range keys {
+		if keys[i] == key {
+			x.userURLs[l.UserID] = append(keys[:i:i], keys[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package links
+
+import (
+	"github.com/stretchr/testify/assert"
+	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"testing"
+	"time"
+)
+
+func TestIndexCheck(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	x := NewIndex()
+	x.Set("live", Link{OriginalURL: "https://live.example.com", UserID: "user"})
+	x.Set("deleted", Link{OriginalURL: "https://deleted.example.com", UserID: "user", IsDeleted: true})
+	x.Set("expired", Link{OriginalURL: "https://expired.example.com", UserID: "user", ExpiresAt: &past})
+
+	tests := []struct {
+		name    string
+		key     string
+		value   string
+		wantErr error
+	}{
+		{name: "new", key: "new", value: "https://new.example.com"},
+		{name: "live url", key: "new", value: "https://live.example.com", wantErr: errs.ErrConflict},
+		{name: "live key", key: "live", value: "https://new.example.com", wantErr: errs.ErrKeyExists},
+		{name: "dead url", key: "new", value: "https://deleted.example.com"},
+		{name: "dead url with its key", key: "expired", value: "https://expired.example.com"},
+		{name: "dead key of another url", key: "deleted", value: "https://new.example.com", wantErr: errs.ErrKeyExists},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, x.Check(tt.key, tt.value, now), tt.wantErr)
+		})
+	}
+}
+
+func TestIndexCheckBatch(t *testing.T) {
+	x := NewIndex()
+
+	assert.NoError(t, x.CheckBatch([]models.URLItem{
+		{ShortURL: "a", OriginalURL: "https://a.example.com"},
+		{ShortURL: "b", OriginalURL: "https://b.example.com"},
+	}, time.Now()))
+
+	assert.ErrorIs(t, x.CheckBatch([]models.URLItem{
+		{ShortURL: "a", OriginalURL: "https://a.example.com"},
+		{ShortURL: "b", OriginalURL: "https://a.example.com"},
+	}, time.Now()), errs.ErrConflict)
+
+	assert.ErrorIs(t, x.CheckBatch([]models.URLItem{
+		{ShortURL: "a", OriginalURL: "https://a.example.com"},
+		{ShortURL: "a", OriginalURL: "https://b.example.com"},
+	}, time.Now()), errs.ErrKeyExists)
+}
+
+func TestIndexSetReplacesDeadLink(t *testing.T) {
+	x := NewIndex()
+	x.Set("old", Link{OriginalURL: "https://example.com", UserID: "alice"})
+
+	_, replaced := x.Set("old", Link{OriginalURL: "https://example.com", UserID: "alice", IsDeleted: true})
+	assert.False(t, replaced, "a tombstone replaces nothing")
+
+	key, replaced := x.Set("new", Link{OriginalURL: "https://example.com", UserID: "bob"})
+	assert.True(t, replaced)
+	assert.Equal(t, "old", key)
+
+	_, ok := x.Get("old")
+	assert.False(t, ok)
+	assert.Empty(t, x.UserKeys("alice"))
+	assert.Equal(t, []string{"new"}, x.UserKeys("bob"))
+
+	live, ok := x.Live("https://example.com", time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, "new", live)
+}
+
+func TestIndexDeletable(t *testing.T) {
+	x := NewIndex()
+	x.Set("a", Link{OriginalURL: "https://a.example.com", UserID: "alice"})
+	x.Set("b", Link{OriginalURL: "https://b.example.com", UserID: "alice", IsDeleted: true})
+	x.Set("c", Link{OriginalURL: "https://c.example.com", UserID: "bob"})
+
+	assert.Equal(t, []string{"a"}, x.Deletable([]string{"a", "b", "c", "d", "a"}, "alice"))
+}
@@ -0,0 +1,17 @@
+package mapstorage_test
+
+import (
+	"github.com/stretchr/testify/require"
+	"shortener/internal/storage"
+	mapStorage "shortener/internal/storage/map"
+	"shortener/internal/storage/storagetest"
+	"testing"
+)
+
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := mapStorage.NewStorage()
+		require.NoError(t, err)
+		return s
+	})
+}
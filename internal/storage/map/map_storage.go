@@ -3,61 +3,155 @@ package mapstorage
 import (
 	"context"
 	"shortener/internal/models"
+	"shortener/internal/storage/errs"
+	"shortener/internal/storage/links"
+	"shortener/internal/storage/visits"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type storage struct {
-	records map[string]storageItem
+	mu       sync.RWMutex
+	links    *links.Index
+	visits   *visits.Counter
+	sequence uint64
 }
 
-type storageItem struct {
-	OriginalURL string
-	UserID      string
-	IsDeleted   bool
+func (s *storage) Get(ctx context.Context, key string) (models.URLItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.links.Get(key)
+	if !ok {
+		return models.URLItem{}, errs.ErrNotFound
+	}
+
+	return l.Item(key), nil
 }
 
-func (s *storage) Get(ctx context.Context, key string) (models.URLItem, error) {
-	v := s.records[key]
+func (s *storage) GetByOriginalURL(ctx context.Context, originalURL string) (models.URLItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	return models.URLItem{
-		OriginalURL: v.OriginalURL,
-		ShortURL:    key,
-		IsDeleted:   v.IsDeleted,
-	}, nil
+	key, ok := s.links.Live(originalURL, time.Now())
+	if !ok {
+		return models.URLItem{}, errs.ErrNotFound
+	}
+
+	l, _ := s.links.Get(key)
+
+	return l.Item(key), nil
 }
 
-func (s *storage) Put(ctx context.Context, key, value, userID string) error {
-	s.records[key] = storageItem{OriginalURL: value, UserID: userID, IsDeleted: false}
+func (s *storage) Put(ctx context.Context, key, value, userID string, expiresAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.links.Check(key, value, time.Now()); err != nil {
+		return err
+	}
+
+	s.set(key, links.Link{OriginalURL: value, UserID: userID, ExpiresAt: expiresAt})
+
+	return nil
+}
+
+// set stores a link in place of a dead link of the same original URL, if
+// there is one, and drops the visits of the replaced link. The caller must
+// hold the write lock.
+func (s *storage) set(key string, l links.Link) {
+	if replaced, ok := s.links.Set(key, l); ok {
+		s.visits.Remove(replaced)
+	}
+}
+
+// SaveVisits only counts visits: referrers, user agents and IP hashes are
+// dropped, since nothing would outlive the process to use them.
+func (s *storage) SaveVisits(ctx context.Context, visits []models.Visit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, v := range visits {
+		s.visits.Add(v)
+	}
 
 	return nil
 }
 
+func (s *storage) GetStats(ctx context.Context, shortURL, userID string) (models.LinkStats, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	l, ok := s.links.Get(shortURL)
+	if !ok || l.UserID != userID {
+		return models.LinkStats{}, errs.ErrNotFound
+	}
+
+	return s.visits.Stats(shortURL), nil
+}
+
+func (s *storage) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.links.Len(), nil
+}
+
+func (s *storage) NextSequence(ctx context.Context) (uint64, error) {
+	return atomic.AddUint64(&s.sequence, 1), nil
+}
+
 func (s *storage) Ping(ctx context.Context) error {
 	return nil
 }
 
+func (s *storage) Close() error {
+	return nil
+}
+
 func (s *storage) Batch(ctx context.Context, urls []models.URLItem, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.links.CheckBatch(urls, time.Now()); err != nil {
+		return err
+	}
+
 	for _, url := range urls {
-		if err := s.Put(ctx, url.ShortURL, url.OriginalURL, userID); err != nil {
-			return err
-		}
+		s.set(url.ShortURL, links.Link{OriginalURL: url.OriginalURL, UserID: userID, ExpiresAt: url.ExpiresAt})
 	}
 	return nil
 }
 
 func (s *storage) DeleteURLs(ctx context.Context, shortURLs []string, userID string) error {
-	for _, shortURL := range shortURLs {
-		item, ok := s.records[shortURL]
-		if ok && item.UserID == userID {
-			s.records[shortURL] = storageItem{OriginalURL: item.OriginalURL, UserID: userID, IsDeleted: true}
-		}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.links.Deletable(shortURLs, userID) {
+		l, _ := s.links.Get(key)
+		l.IsDeleted = true
+		s.set(key, l)
 	}
 	return nil
 }
 
 func (s *storage) GetAllURLs(ctx context.Context, userID string) ([]models.URLItem, error) {
-	return nil, nil
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var urls []models.URLItem
+	for _, key := range s.links.UserKeys(userID) {
+		l, _ := s.links.Get(key)
+		urls = append(urls, l.Item(key))
+	}
+
+	return urls, nil
 }
 
 func NewStorage() (*storage, error) {
-	return &storage{records: map[string]storageItem{}}, nil
+	return &storage{
+		links:  links.NewIndex(),
+		visits: visits.NewCounter(),
+	}, nil
 }
@@ -0,0 +1,68 @@
+package mapstorage
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestStorageParallel(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage()
+	require.NoError(t, err)
+
+	const users, perUser = 8, 50
+
+	var wg sync.WaitGroup
+	for u := 0; u < users; u++ {
+		wg.Add(1)
+		go func(u int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user%d", u)
+
+			var keys []string
+			for i := 0; i < perUser; i++ {
+				key := fmt.Sprintf("%s-%d", userID, i)
+				keys = append(keys, key)
+				assert.NoError(t, s.Put(ctx, key, "https://example.com/"+key, userID, nil))
+				_, err := s.Get(ctx, key)
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, s.DeleteURLs(ctx, keys[:perUser/2], userID))
+			_, err := s.GetAllURLs(ctx, userID)
+			assert.NoError(t, err)
+		}(u)
+	}
+	wg.Wait()
+
+	for u := 0; u < users; u++ {
+		urls, err := s.GetAllURLs(ctx, fmt.Sprintf("user%d", u))
+		require.NoError(t, err)
+		require.Len(t, urls, perUser)
+
+		deleted := 0
+		for _, url := range urls {
+			if url.IsDeleted {
+				deleted++
+			}
+		}
+		assert.Equal(t, perUser/2, deleted)
+	}
+}
+
+func TestStorageDeleteURLsOnlyOwn(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewStorage()
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put(ctx, "aaa", "https://a.example.com", "alice", nil))
+	require.NoError(t, s.DeleteURLs(ctx, []string{"aaa"}, "bob"))
+
+	item, err := s.Get(ctx, "aaa")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted)
+}
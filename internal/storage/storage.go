@@ -2,25 +2,85 @@ package storage
 
 import (
 	"context"
+	"go.uber.org/zap"
 	"shortener/config"
 	"shortener/internal/models"
 	"shortener/internal/storage/db"
+	"shortener/internal/storage/errs"
 	"shortener/internal/storage/file"
 	mapStorage "shortener/internal/storage/map"
+	"time"
+)
+
+var (
+	// ErrConflict is returned by Put and Batch when the original URL is
+	// already stored.
+	ErrConflict = errs.ErrConflict
+	// ErrNotFound is returned by Get when there is no URL for the key.
+	ErrNotFound = errs.ErrNotFound
+	// ErrKeyExists is returned when the short URL is already used for a
+	// different original URL.
+	ErrKeyExists = errs.ErrKeyExists
 )
 
 type Storage interface {
 	Get(ctx context.Context, key string) (models.URLItem, error)
-	Put(ctx context.Context, key, value string, userID string) error
+	GetByOriginalURL(ctx context.Context, originalURL string) (models.URLItem, error)
+	Put(ctx context.Context, key, value string, userID string, expiresAt *time.Time) error
 	Batch(ctx context.Context, urls []models.URLItem, userID string) error
 	GetAllURLs(ctx context.Context, userID string) ([]models.URLItem, error)
 	DeleteURLs(ctx context.Context, urls []string, userID string) error
+	Count(ctx context.Context) (int, error)
+	// NextSequence returns a number greater than any it returned before,
+	// also before a restart and to other instances sharing the storage.
+	// Numbers may be skipped. Counter based short codes are made from it.
+	NextSequence(ctx context.Context) (uint64, error)
 	Ping(ctx context.Context) error
+	Close() error
+	VisitStorage
+}
+
+// VisitStorage keeps the redirect log used for click analytics. The database
+// and the file storage keep every field of a visit; the map storage only
+// keeps daily counts, which is all GetStats reports. The in-memory counts of
+// the map and file storages cover the last visits.MaxDays days.
+type VisitStorage interface {
+	SaveVisits(ctx context.Context, visits []models.Visit) error
+	// GetStats returns ErrNotFound unless the link exists and belongs to userID.
+	GetStats(ctx context.Context, shortURL, userID string) (models.LinkStats, error)
+}
+
+// APIKeyStorage keeps API keys by the hash of their secret. Only the
+// database storage implements it.
+type APIKeyStorage interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey, keyHash string) error
+	// UseAPIKey returns ErrNotFound for unknown and revoked keys. It sets the
+	// last use of the key to now unless that was recorded less than a minute
+	// ago, so that a busy key is not written to on every request.
+	UseAPIKey(ctx context.Context, keyHash string, now time.Time) (models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID string) ([]models.APIKey, error)
+	// RevokeAPIKey returns ErrNotFound unless an active key id belongs to userID.
+	RevokeAPIKey(ctx context.Context, id, userID string) error
+}
+
+// UserStorage keeps registered accounts. Only the database storage
+// implements it.
+type UserStorage interface {
+	// CreateUserAndClaim creates the account and, in the same transaction,
+	// moves everything owned by anonymousID to it like ClaimURLs. An empty
+	// anonymousID moves nothing. It returns ErrConflict when the login is
+	// taken.
+	CreateUserAndClaim(ctx context.Context, user models.User, passwordHash []byte, anonymousID string) error
+	// GetUserByLogin returns ErrNotFound for unknown logins.
+	GetUserByLogin(ctx context.Context, login string) (models.User, []byte, error)
+	// ClaimURLs moves everything owned by an anonymous identity to userID
+	// and returns the number of moved links.
+	ClaimURLs(ctx context.Context, anonymousID, userID string) (int64, error)
 }
 
-func NewStorage(config config.Config) (Storage, error) {
+func NewStorage(config config.Config, logger *zap.SugaredLogger) (Storage, error) {
 	if config.DatabaseDSN != "" {
-		return db.NewStorage(config.DatabaseDSN)
+		return db.NewStorage(config.DatabaseDSN, logger)
 	}
 
 	if config.FileStoragePath != "" {
@@ -0,0 +1,105 @@
+package storagetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"testing"
+	"time"
+)
+
+// APIKeyFactory returns an empty API key storage for a single test case.
+type APIKeyFactory func(t *testing.T) storage.APIKeyStorage
+
+// RunAPIKeys executes the API key suite against storages created by
+// newStorage.
+func RunAPIKeys(t *testing.T, newStorage APIKeyFactory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.APIKeyStorage)
+	}{
+		{"CreateUse", testAPIKeyCreateUse},
+		{"List", testAPIKeyList},
+		{"Revoke", testAPIKeyRevoke},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStorage(t))
+		})
+	}
+}
+
+func newAPIKey(id, userID string) models.APIKey {
+	return models.APIKey{
+		ID:        id,
+		UserID:    userID,
+		Name:      "key " + id,
+		Scopes:    []string{"shorten", "read"},
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+}
+
+func testAPIKeyCreateUse(t *testing.T, s storage.APIKeyStorage) {
+	ctx := context.Background()
+
+	key := newAPIKey("00000000-0000-0000-0000-000000000001", "user")
+	require.NoError(t, s.CreateAPIKey(ctx, key, "hash1"))
+
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	got, err := s.UseAPIKey(ctx, "hash1", now)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, got.ID)
+	assert.Equal(t, "user", got.UserID)
+	assert.Equal(t, key.Scopes, got.Scopes)
+	require.NotNil(t, got.LastUsedAt)
+	assert.True(t, now.Equal(*got.LastUsedAt))
+
+	got, err = s.UseAPIKey(ctx, "hash1", now.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, now.Equal(*got.LastUsedAt), "a recent use is not recorded again")
+
+	later := now.Add(2 * time.Minute)
+	got, err = s.UseAPIKey(ctx, "hash1", later)
+	require.NoError(t, err)
+	assert.True(t, later.Equal(*got.LastUsedAt))
+
+	_, err = s.UseAPIKey(ctx, "unknown", now)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testAPIKeyList(t *testing.T, s storage.APIKeyStorage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.CreateAPIKey(ctx, newAPIKey("00000000-0000-0000-0000-000000000001", "user"), "hash1"))
+	require.NoError(t, s.CreateAPIKey(ctx, newAPIKey("00000000-0000-0000-0000-000000000002", "user"), "hash2"))
+	require.NoError(t, s.CreateAPIKey(ctx, newAPIKey("00000000-0000-0000-0000-000000000003", "other"), "hash3"))
+
+	keys, err := s.ListAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	keys, err = s.ListAPIKeys(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func testAPIKeyRevoke(t *testing.T, s storage.APIKeyStorage) {
+	ctx := context.Background()
+
+	id := "00000000-0000-0000-0000-000000000001"
+	require.NoError(t, s.CreateAPIKey(ctx, newAPIKey(id, "user"), "hash1"))
+
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, id, "other"), storage.ErrNotFound)
+	require.NoError(t, s.RevokeAPIKey(ctx, id, "user"))
+	assert.ErrorIs(t, s.RevokeAPIKey(ctx, id, "user"), storage.ErrNotFound)
+
+	_, err := s.UseAPIKey(ctx, "hash1", time.Now())
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	keys, err := s.ListAPIKeys(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+}
@@ -0,0 +1,342 @@
+// Package storagetest provides a conformance suite that every storage.Storage
+// implementation is expected to pass.
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory returns an empty storage for a single test case.
+type Factory func(t *testing.T) storage.Storage
+
+// Run executes the conformance suite against storages created by newStorage.
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage)
+	}{
+		{"PutGet", testPutGet},
+		{"Expiry", testExpiry},
+		{"NotFound", testNotFound},
+		{"GetByOriginalURL", testGetByOriginalURL},
+		{"Conflict", testConflict},
+		{"KeyExists", testKeyExists},
+		{"BatchConflict", testBatchConflict},
+		{"Batch", testBatch},
+		{"GetAllURLs", testGetAllURLs},
+		{"DeleteURLs", testDeleteURLs},
+		{"ReuseDeadURL", testReuseDeadURL},
+		{"Count", testCount},
+		{"NextSequence", testNextSequence},
+		{"Visits", testVisits},
+		{"Concurrency", testConcurrency},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.fn(t, newStorage(t))
+		})
+	}
+}
+
+func testPutGet(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "put00001", "https://example.com/put", "user", nil))
+
+	item, err := s.Get(ctx, "put00001")
+	require.NoError(t, err)
+	assert.Equal(t, "put00001", item.ShortURL)
+	assert.Equal(t, "https://example.com/put", item.OriginalURL)
+	assert.False(t, item.IsDeleted)
+}
+
+func testExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	require.NoError(t, s.Put(ctx, "expiry01", "https://example.com/expiry/1", "user", &expiresAt))
+	require.NoError(t, s.Batch(ctx, []models.URLItem{
+		{ShortURL: "expiry02", OriginalURL: "https://example.com/expiry/2", ExpiresAt: &expiresAt},
+	}, "user"))
+
+	for _, key := range []string{"expiry01", "expiry02"} {
+		item, err := s.Get(ctx, key)
+		require.NoError(t, err)
+		require.NotNil(t, item.ExpiresAt)
+		assert.True(t, expiresAt.Equal(*item.ExpiresAt), "got %v, want %v", item.ExpiresAt, expiresAt)
+	}
+
+	item, err := s.Get(ctx, "expiry01")
+	require.NoError(t, err)
+	assert.False(t, item.IsExpired(time.Now()))
+	assert.True(t, item.IsExpired(expiresAt))
+}
+
+func testNotFound(t *testing.T, s storage.Storage) {
+	_, err := s.Get(context.Background(), "missing1")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "conflict", "https://example.com/conflict", "user", nil))
+
+	err := s.Put(ctx, "conflict", "https://example.com/conflict", "other", nil)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	item, err := s.Get(ctx, "conflict")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/conflict", item.OriginalURL)
+}
+
+func testGetByOriginalURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "origin01", "https://example.com/origin", "user", nil))
+
+	item, err := s.GetByOriginalURL(ctx, "https://example.com/origin")
+	require.NoError(t, err)
+	assert.Equal(t, "origin01", item.ShortURL)
+
+	_, err = s.GetByOriginalURL(ctx, "https://example.com/missing")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testReuseDeadURL(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	past := time.Now().Add(-time.Minute)
+
+	require.NoError(t, s.Put(ctx, "expired1", "https://example.com/expired", "user", &past))
+	require.NoError(t, s.Put(ctx, "deleted1", "https://example.com/deleted", "user", nil))
+	require.NoError(t, s.DeleteURLs(ctx, []string{"deleted1"}, "user"))
+
+	for _, url := range []string{"https://example.com/expired", "https://example.com/deleted"} {
+		_, err := s.GetByOriginalURL(ctx, url)
+		assert.ErrorIs(t, err, storage.ErrNotFound, "dead link of %s", url)
+	}
+
+	require.NoError(t, s.Put(ctx, "expired2", "https://example.com/expired", "other", nil), "a new code takes over an expired url")
+	require.NoError(t, s.Put(ctx, "deleted1", "https://example.com/deleted", "other", nil), "a dead link's own code can be reused")
+
+	item, err := s.GetByOriginalURL(ctx, "https://example.com/expired")
+	require.NoError(t, err)
+	assert.Equal(t, "expired2", item.ShortURL)
+
+	item, err = s.Get(ctx, "deleted1")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted)
+
+	urls, err := s.GetAllURLs(ctx, "user")
+	require.NoError(t, err)
+	assert.Empty(t, urls, "replaced links leave their previous owner")
+
+	urls, err = s.GetAllURLs(ctx, "other")
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	require.NoError(t, s.Put(ctx, "batch001", "https://example.com/batch", "user", &past))
+	require.NoError(t, s.Batch(ctx, []models.URLItem{
+		{ShortURL: "batch002", OriginalURL: "https://example.com/batch"},
+	}, "other"), "batches take over dead links too")
+
+	err = s.Put(ctx, "expired3", "https://example.com/expired", "user", nil)
+	assert.ErrorIs(t, err, storage.ErrConflict, "the new link is live")
+}
+
+func testKeyExists(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "collide1", "https://example.com/first", "user", nil))
+
+	err := s.Put(ctx, "collide1", "https://example.com/second", "user", nil)
+	assert.ErrorIs(t, err, storage.ErrKeyExists)
+
+	item, err := s.Get(ctx, "collide1")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/first", item.OriginalURL, "existing link must not be overwritten")
+}
+
+func testBatchConflict(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "existing", "https://example.com/existing", "user", nil))
+
+	err := s.Batch(ctx, []models.URLItem{
+		{ShortURL: "fresh001", OriginalURL: "https://example.com/fresh"},
+		{ShortURL: "existing", OriginalURL: "https://example.com/other"},
+	}, "user")
+	assert.ErrorIs(t, err, storage.ErrKeyExists)
+
+	_, err = s.Get(ctx, "fresh001")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "failed batch must not be partially saved")
+
+	err = s.Batch(ctx, []models.URLItem{
+		{ShortURL: "fresh002", OriginalURL: "https://example.com/existing"},
+	}, "user")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
+
+func testBatch(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	batch := []models.URLItem{
+		{CorrelationID: "1", ShortURL: "batch001", OriginalURL: "https://example.com/batch/1"},
+		{CorrelationID: "2", ShortURL: "batch002", OriginalURL: "https://example.com/batch/2"},
+		{CorrelationID: "3", ShortURL: "batch003", OriginalURL: "https://example.com/batch/3"},
+	}
+	require.NoError(t, s.Batch(ctx, batch, "user"))
+
+	for _, want := range batch {
+		item, err := s.Get(ctx, want.ShortURL)
+		require.NoError(t, err)
+		assert.Equal(t, want.OriginalURL, item.OriginalURL)
+	}
+}
+
+func testGetAllURLs(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "alice001", "https://example.com/alice/1", "alice", nil))
+	require.NoError(t, s.Put(ctx, "alice002", "https://example.com/alice/2", "alice", nil))
+	require.NoError(t, s.Put(ctx, "bob00001", "https://example.com/bob/1", "bob", nil))
+
+	urls, err := s.GetAllURLs(ctx, "alice")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"alice001", "alice002"}, shortURLs(urls))
+
+	urls, err = s.GetAllURLs(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, urls)
+}
+
+func testDeleteURLs(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "delete01", "https://example.com/delete/1", "alice", nil))
+	require.NoError(t, s.Put(ctx, "delete02", "https://example.com/delete/2", "alice", nil))
+	require.NoError(t, s.Put(ctx, "delete03", "https://example.com/delete/3", "bob", nil))
+
+	require.NoError(t, s.DeleteURLs(ctx, []string{"delete01", "delete03", "missing1"}, "alice"))
+
+	item, err := s.Get(ctx, "delete01")
+	require.NoError(t, err)
+	assert.True(t, item.IsDeleted)
+	assert.Equal(t, "https://example.com/delete/1", item.OriginalURL)
+
+	item, err = s.Get(ctx, "delete02")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted)
+
+	item, err = s.Get(ctx, "delete03")
+	require.NoError(t, err)
+	assert.False(t, item.IsDeleted, "links of other users must not be deleted")
+}
+
+func testCount(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	count, err := s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	require.NoError(t, s.Put(ctx, "count001", "https://example.com/count/1", "user", nil))
+	require.NoError(t, s.Put(ctx, "count002", "https://example.com/count/2", "user", nil))
+
+	count, err = s.Count(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func testNextSequence(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	var last uint64
+	for i := 0; i < 3; i++ {
+		n, err := s.NextSequence(ctx)
+		require.NoError(t, err)
+		assert.Greater(t, n, last)
+		last = n
+	}
+}
+
+func testVisits(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "visits01", "https://example.com/visits", "alice", nil))
+
+	day := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, s.SaveVisits(ctx, []models.Visit{
+		{ShortURL: "visits01", VisitedAt: day, Referrer: "https://ref.example.com", UserAgent: "test", IPHash: "hash"},
+		{ShortURL: "visits01", VisitedAt: day.Add(time.Hour)},
+		{ShortURL: "visits01", VisitedAt: day.Add(24 * time.Hour)},
+		// A visit of a link freed in the meantime must not fail the others.
+		{ShortURL: "gone0001", VisitedAt: day},
+	}))
+
+	stats, err := s.GetStats(ctx, "visits01", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Total)
+	assert.Equal(t, []models.DailyVisits{
+		{Date: "2024-03-01", Visits: 2},
+		{Date: "2024-03-02", Visits: 1},
+	}, stats.Daily)
+
+	_, err = s.GetStats(ctx, "visits01", "bob")
+	assert.ErrorIs(t, err, storage.ErrNotFound, "stats of other users' links must not be visible")
+
+	_, err = s.GetStats(ctx, "missing1", "alice")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testConcurrency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	const workers, perWorker = 8, 25
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			userID := fmt.Sprintf("user%d", w)
+
+			var keys []string
+			for i := 0; i < perWorker; i++ {
+				key := fmt.Sprintf("c%02d%05d", w, i)
+				keys = append(keys, key)
+				assert.NoError(t, s.Put(ctx, key, "https://example.com/"+key, userID, nil))
+				_, err := s.Get(ctx, key)
+				assert.NoError(t, err)
+			}
+
+			assert.NoError(t, s.DeleteURLs(ctx, keys[:1], userID))
+			_, err := s.GetAllURLs(ctx, userID)
+			assert.NoError(t, err)
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		urls, err := s.GetAllURLs(ctx, fmt.Sprintf("user%d", w))
+		require.NoError(t, err)
+		assert.Len(t, urls, perWorker)
+	}
+}
+
+func shortURLs(urls []models.URLItem) []string {
+	var keys []string
+	for _, u := range urls {
+		keys = append(keys, u.ShortURL)
+	}
+	return keys
+}
@@ -0,0 +1,108 @@
+package storagetest
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"shortener/internal/models"
+	"shortener/internal/storage"
+	"testing"
+	"time"
+)
+
+// RunUsers executes the account suite against storages created by
+// newStorage, which must also implement storage.UserStorage.
+func RunUsers(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s storage.Storage, u storage.UserStorage)
+	}{
+		{"CreateGet", testUserCreateGet},
+		{"ClaimURLs", testUserClaimURLs},
+		{"CreateAndClaim", testUserCreateAndClaim},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newStorage(t)
+			u, ok := s.(storage.UserStorage)
+			require.True(t, ok, "storage does not keep users")
+			test.fn(t, s, u)
+		})
+	}
+}
+
+func newUser(id, login string) models.User {
+	return models.User{ID: id, Login: login, CreatedAt: time.Now().UTC().Truncate(time.Microsecond)}
+}
+
+func testUserCreateGet(t *testing.T, s storage.Storage, u storage.UserStorage) {
+	ctx := context.Background()
+
+	user := newUser("00000000-0000-0000-0000-000000000001", "alice")
+	require.NoError(t, u.CreateUserAndClaim(ctx, user, []byte("hash"), ""))
+
+	got, hash, err := u.GetUserByLogin(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, got.ID)
+	assert.True(t, user.CreatedAt.Equal(got.CreatedAt))
+	assert.Equal(t, []byte("hash"), hash)
+
+	err = u.CreateUserAndClaim(ctx, newUser("00000000-0000-0000-0000-000000000002", "alice"), []byte("hash"), "anon")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	_, _, err = u.GetUserByLogin(ctx, "bob")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testUserClaimURLs(t *testing.T, s storage.Storage, u storage.UserStorage) {
+	ctx := context.Background()
+
+	alice := newUser("00000000-0000-0000-0000-000000000001", "alice")
+	bob := newUser("00000000-0000-0000-0000-000000000002", "bob")
+	require.NoError(t, u.CreateUserAndClaim(ctx, alice, []byte("hash"), ""))
+	require.NoError(t, u.CreateUserAndClaim(ctx, bob, []byte("hash"), ""))
+
+	require.NoError(t, s.Put(ctx, "claim001", "https://example.com/anon1", "anon", nil))
+	require.NoError(t, s.Put(ctx, "claim002", "https://example.com/anon2", "anon", nil))
+	require.NoError(t, s.Put(ctx, "claim003", "https://example.com/bob", bob.ID, nil))
+
+	n, err := u.ClaimURLs(ctx, "anon", alice.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, n)
+
+	urls, err := s.GetAllURLs(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 2)
+
+	// Links of a registered user are never claimed by someone else.
+	n, err = u.ClaimURLs(ctx, bob.ID, alice.ID)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	urls, err = s.GetAllURLs(ctx, bob.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
+
+func testUserCreateAndClaim(t *testing.T, s storage.Storage, u storage.UserStorage) {
+	ctx := context.Background()
+
+	require.NoError(t, s.Put(ctx, "claim001", "https://example.com/anon", "anon", nil))
+
+	alice := newUser("00000000-0000-0000-0000-000000000001", "alice")
+	require.NoError(t, u.CreateUserAndClaim(ctx, alice, []byte("hash"), "anon"))
+
+	urls, err := s.GetAllURLs(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+
+	// A taken login neither creates the account nor moves any links.
+	require.NoError(t, s.Put(ctx, "claim002", "https://example.com/anon2", "anon2", nil))
+	err = u.CreateUserAndClaim(ctx, newUser("00000000-0000-0000-0000-000000000002", "alice"), []byte("hash"), "anon2")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	urls, err = s.GetAllURLs(ctx, "anon2")
+	require.NoError(t, err)
+	assert.Len(t, urls, 1)
+}
@@ -0,0 +1,70 @@
+// Package visits aggregates redirect logs for the storage backends that keep
+// their data in memory. Only daily counts are kept.
+package visits
+
+import (
+	"shortener/internal/models"
+	"sort"
+)
+
+const dateLayout = "2006-01-02"
+
+// MaxDays is how many days of visits are counted per short URL. Older days
+// are forgotten, so that the counter does not grow without bound.
+const MaxDays = 366
+
+// Counter counts visits per short URL and UTC day. It is not safe for
+// concurrent use; callers guard it with their own lock.
+type Counter struct {
+	daily map[string]map[string]int64
+}
+
+func NewCounter() *Counter {
+	return &Counter{daily: map[string]map[string]int64{}}
+}
+
+func (c *Counter) Add(v models.Visit) {
+	days, ok := c.daily[v.ShortURL]
+	if !ok {
+		days = map[string]int64{}
+		c.daily[v.ShortURL] = days
+	}
+
+	days[v.VisitedAt.UTC().Format(dateLayout)]++
+
+	if len(days) > MaxDays {
+		delete(days, oldest(days))
+	}
+}
+
+// oldest returns the earliest of days, which sort as strings.
+func oldest(days map[string]int64) string {
+	var first string
+	for date := range days {
+		if first == "" || date < first {
+			first = date
+		}
+	}
+
+	return first
+}
+
+// Remove forgets the visits of shortURL.
+func (c *Counter) Remove(shortURL string) {
+	delete(c.daily, shortURL)
+}
+
+// Stats returns the totals for shortURL with days in ascending order.
+func (c *Counter) Stats(shortURL string) models.LinkStats {
+	stats := models.LinkStats{ShortURL: shortURL, Daily: []models.DailyVisits{}}
+	for date, count := range c.daily[shortURL] {
+		stats.Total += count
+		stats.Daily = append(stats.Daily, models.DailyVisits{Date: date, Visits: count})
+	}
+
+	sort.Slice(stats.Daily, func(i, j int) bool {
+		return stats.Daily[i].Date < stats.Daily[j].Date
+	})
+
+	return stats
+}
@@ -0,0 +1,23 @@
+package visits
+
+import (
+	"github.com/stretchr/testify/assert"
+	"shortener/internal/models"
+	"testing"
+	"time"
+)
+
+func TestCounterForgetsOldDays(t *testing.T) {
+	c := NewCounter()
+	start := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
+	for day := 0; day <= MaxDays; day++ {
+		c.Add(models.Visit{ShortURL: "link", VisitedAt: start.AddDate(0, 0, day)})
+	}
+	c.Add(models.Visit{ShortURL: "link", VisitedAt: start.AddDate(0, 0, MaxDays)})
+
+	stats := c.Stats("link")
+	assert.Len(t, stats.Daily, MaxDays)
+	assert.Equal(t, "2023-01-02", stats.Daily[0].Date)
+	assert.EqualValues(t, 2, stats.Daily[MaxDays-1].Visits)
+	assert.EqualValues(t, MaxDays+1, stats.Total)
+}
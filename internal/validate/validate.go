@@ -0,0 +1,376 @@
+// Package validate decides which destination URLs may be shortened.
+package validate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"shortener/config"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolveTimeout bounds the DNS lookup done to find private addresses.
+const resolveTimeout = 2 * time.Second
+
+// maxConcurrentLookups bounds the DNS lookups CheckAll runs at once.
+const maxConcurrentLookups = 16
+
+var (
+	ErrInvalidURL       = errors.New("invalid url")
+	ErrSchemeNotAllowed = errors.New("scheme is not allowed")
+	ErrDomainBlocked    = errors.New("domain is blocked")
+	ErrPrivateAddress   = errors.New("private and loopback addresses are not allowed")
+	ErrSelfReference    = errors.New("links to this service are not allowed")
+)
+
+var defaultAllowedSchemes = []string{"http", "https"}
+
+// Error is returned for URLs the policy rejects. It wraps one of the
+// sentinel errors above.
+type Error struct {
+	URL string
+	Err error
+	// Detail says what exactly was wrong, e.g. the scheme or the domain.
+	Detail string
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%s: %s", e.Err, e.URL)
+	}
+	return fmt.Sprintf("%s: %s", e.Err, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Resolver looks host names up; net.DefaultResolver satisfies it.
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type Policy struct {
+	schemes      map[string]bool
+	blocked      map[string]bool
+	blockPrivate bool
+	selfHost     string
+	resolver     Resolver
+}
+
+// NewPolicy builds the policy from cfg, reading the domain blocklist file if
+// one is configured.
+func NewPolicy(cfg config.Config) (*Policy, error) {
+	p := &Policy{
+		schemes:      map[string]bool{},
+		blocked:      map[string]bool{},
+		blockPrivate: cfg.BlockPrivateNetworks,
+		resolver:     net.DefaultResolver,
+	}
+
+	schemes := cfg.AllowedSchemes
+	if len(schemes) == 0 {
+		schemes = defaultAllowedSchemes
+	}
+	for _, scheme := range schemes {
+		p.schemes[strings.ToLower(scheme)] = true
+	}
+
+	if base, err := url.Parse(cfg.BaseURL); err == nil {
+		p.selfHost = strings.ToLower(base.Hostname())
+	}
+
+	if cfg.URLBlocklistFile != "" {
+		if err := p.loadBlocklist(cfg.URLBlocklistFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// loadBlocklist reads one domain per line. Blank lines and lines starting
+// with # are skipped.
+func (p *Policy) loadBlocklist(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open url blocklist: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.blocked[strings.TrimSuffix(strings.ToLower(line), ".")] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read url blocklist: %w", err)
+	}
+
+	return nil
+}
+
+// Check returns an *Error if rawURL may not be shortened.
+func (p *Policy) Check(ctx context.Context, rawURL string) error {
+	return p.check(ctx, rawURL, p.lookup)
+}
+
+// CheckAll checks rawURLs like Check, but concurrently and looking every
+// distinct host up only once. It returns the index and error of the first
+// url that may not be shortened, or -1 and nil.
+func (p *Policy) CheckAll(ctx context.Context, rawURLs []string) (int, error) {
+	shared := &sharedLookup{
+		lookup:  p.lookup,
+		sem:     make(chan struct{}, maxConcurrentLookups),
+		results: map[string]*lookupResult{},
+	}
+
+	errs := make([]error, len(rawURLs))
+	var wg sync.WaitGroup
+	for i := range rawURLs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = p.check(ctx, rawURLs[i], shared.Lookup)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return -1, nil
+}
+
+// lookupFunc resolves host to its addresses.
+type lookupFunc func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+func (p *Policy) check(ctx context.Context, rawURL string, lookup lookupFunc) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme == "" {
+		return &Error{URL: rawURL, Err: ErrInvalidURL}
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !p.schemes[scheme] {
+		return &Error{URL: rawURL, Err: ErrSchemeNotAllowed, Detail: scheme}
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		if scheme == "http" || scheme == "https" {
+			return &Error{URL: rawURL, Err: ErrInvalidURL, Detail: "missing host"}
+		}
+		return nil
+	}
+
+	if host == p.selfHost {
+		return &Error{URL: rawURL, Err: ErrSelfReference}
+	}
+
+	if domain, ok := p.blockedDomain(host); ok {
+		return &Error{URL: rawURL, Err: ErrDomainBlocked, Detail: domain}
+	}
+
+	if p.blockPrivate {
+		return checkAddress(ctx, rawURL, host, lookup)
+	}
+
+	return nil
+}
+
+// blockedDomain matches host and all of its parent domains against the
+// blocklist.
+func (p *Policy) blockedDomain(host string) (string, bool) {
+	for domain := host; domain != ""; {
+		if p.blocked[domain] {
+			return domain, true
+		}
+
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok {
+			break
+		}
+		domain = parent
+	}
+
+	return "", false
+}
+
+func checkAddress(ctx context.Context, rawURL, host string, lookup lookupFunc) error {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		// Browsers read hosts such as 2130706433 or 0x7f.1 as IPv4
+		// addresses, and refuse them if they are not valid ones.
+		var numeric bool
+		if ip, numeric = parseIPv4(host); numeric && ip == nil {
+			return &Error{URL: rawURL, Err: ErrInvalidURL, Detail: "invalid IPv4 address " + host}
+		}
+	}
+
+	if ip != nil {
+		if isPrivate(ip) {
+			return &Error{URL: rawURL, Err: ErrPrivateAddress, Detail: host}
+		}
+		return nil
+	}
+
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return &Error{URL: rawURL, Err: ErrPrivateAddress, Detail: host}
+	}
+
+	// A name that does not resolve now cannot point inside our network
+	// either, so lookup failures let the url through.
+	addrs, err := lookup(ctx, host)
+	if err != nil {
+		return nil
+	}
+
+	for _, addr := range addrs {
+		if isPrivate(addr.IP) {
+			return &Error{URL: rawURL, Err: ErrPrivateAddress, Detail: host}
+		}
+	}
+
+	return nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range, RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func isPrivate(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		sharedAddressSpace.Contains(ip)
+}
+
+// lookup resolves host within resolveTimeout.
+func (p *Policy) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	return p.resolver.LookupIPAddr(ctx, host)
+}
+
+type lookupResult struct {
+	done  chan struct{}
+	addrs []net.IPAddr
+	err   error
+}
+
+// sharedLookup resolves every host once for all its callers and runs at most
+// cap(sem) lookups at a time. Waiting for a turn does not count against
+// resolveTimeout, so a batch of slow hosts cannot make a later lookup fail
+// and let its url through.
+type sharedLookup struct {
+	lookup  lookupFunc
+	sem     chan struct{}
+	mu      sync.Mutex
+	results map[string]*lookupResult
+}
+
+func (l *sharedLookup) Lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	l.mu.Lock()
+	result, ok := l.results[host]
+	if !ok {
+		result = &lookupResult{done: make(chan struct{})}
+		l.results[host] = result
+	}
+	l.mu.Unlock()
+
+	if !ok {
+		select {
+		case l.sem <- struct{}{}:
+			result.addrs, result.err = l.lookup(ctx, host)
+			<-l.sem
+		case <-ctx.Done():
+			result.err = ctx.Err()
+		}
+		close(result.done)
+	}
+
+	select {
+	case <-result.done:
+		return result.addrs, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// parseIPv4 reads host the way browsers do, which besides the dotted decimal
+// form accepts one to four parts in decimal, 0x prefixed hex or 0 prefixed
+// octal, the last one filling the remaining bytes. numeric reports whether
+// host ends in a number and so is meant as an address; ip is nil if it is
+// not a valid one.
+func parseIPv4(host string) (ip net.IP, numeric bool) {
+	labels := strings.Split(host, ".")
+	if !isNumeric(labels[len(labels)-1]) {
+		return nil, false
+	}
+
+	if len(labels) > 4 {
+		return nil, true
+	}
+
+	var addr uint64
+	for i, label := range labels {
+		n, ok := parseIPv4Part(label)
+		if !ok {
+			return nil, true
+		}
+
+		if i < len(labels)-1 {
+			if n > 0xff {
+				return nil, true
+			}
+			addr |= n << (8 * (3 - i))
+			continue
+		}
+
+		if n >= 1<<(8*(4-i)) {
+			return nil, true
+		}
+		addr |= n
+	}
+
+	return net.IPv4(byte(addr>>24), byte(addr>>16), byte(addr>>8), byte(addr)), true
+}
+
+func parseIPv4Part(part string) (uint64, bool) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x"):
+		part, base = part[2:], 16
+		if part == "" {
+			return 0, true
+		}
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+
+	n, err := strconv.ParseUint(part, base, 32)
+	return n, err == nil
+}
+
+// isNumeric reports whether label is a decimal or 0x prefixed hex number.
+func isNumeric(label string) bool {
+	if hex := strings.TrimPrefix(label, "0x"); hex != label {
+		return strings.Trim(hex, "0123456789abcdef") == ""
+	}
+
+	return label != "" && strings.Trim(label, "0123456789") == ""
+}
@@ -0,0 +1,137 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"shortener/config"
+	"sync"
+	"testing"
+)
+
+type resolver map[string]string
+
+func (r resolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	ip, ok := r[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{{IP: net.ParseIP(ip)}}, nil
+}
+
+func TestPolicy(t *testing.T) {
+	blocklist := filepath.Join(t.TempDir(), "blocklist")
+	require.NoError(t, os.WriteFile(blocklist, []byte("# phishing\nevil.example\n\nBAD.example.\n"), 0600))
+
+	policy, err := NewPolicy(config.Config{
+		BaseURL:              "https://sho.rt",
+		AllowedSchemes:       []string{"http", "https", "mailto"},
+		URLBlocklistFile:     blocklist,
+		BlockPrivateNetworks: true,
+	})
+	require.NoError(t, err)
+	policy.resolver = resolver{"good.example": "93.184.216.34", "intranet.example": "10.1.2.3"}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr error
+	}{
+		{name: "public host", url: "https://good.example/path"},
+		{name: "unresolvable host", url: "https://unknown.example"},
+		{name: "allowed scheme without host", url: "mailto:someone@good.example"},
+		{name: "relative url", url: "/path", wantErr: ErrInvalidURL},
+		{name: "missing host", url: "http:///path", wantErr: ErrInvalidURL},
+		{name: "javascript", url: "javascript:alert(1)", wantErr: ErrSchemeNotAllowed},
+		{name: "scheme case", url: "FTP://good.example", wantErr: ErrSchemeNotAllowed},
+		{name: "blocked domain", url: "https://evil.example", wantErr: ErrDomainBlocked},
+		{name: "blocked subdomain", url: "https://login.EVIL.example.", wantErr: ErrDomainBlocked},
+		{name: "blocked with trailing dot in list", url: "https://bad.example", wantErr: ErrDomainBlocked},
+		{name: "self reference", url: "https://SHO.RT/abc", wantErr: ErrSelfReference},
+		{name: "self reference on another port", url: "http://sho.rt:8080/abc", wantErr: ErrSelfReference},
+		{name: "private ip", url: "http://10.0.0.5", wantErr: ErrPrivateAddress},
+		{name: "loopback ip", url: "http://127.0.0.1:8080", wantErr: ErrPrivateAddress},
+		{name: "ipv6 loopback", url: "http://[::1]/", wantErr: ErrPrivateAddress},
+		{name: "link local", url: "http://169.254.169.254/latest", wantErr: ErrPrivateAddress},
+		{name: "decimal ip", url: "http://2130706433/", wantErr: ErrPrivateAddress},
+		{name: "hex ip", url: "http://0x7f.1/", wantErr: ErrPrivateAddress},
+		{name: "octal ip", url: "http://0177.0.0.1/", wantErr: ErrPrivateAddress},
+		{name: "public decimal ip", url: "http://1572395042/"},
+		{name: "public hex ip", url: "http://0x5d.0xb8.0xd8.0x22/"},
+		{name: "out of range ip", url: "http://1.2.3.256/", wantErr: ErrInvalidURL},
+		{name: "numeric top level domain", url: "http://example.123/", wantErr: ErrInvalidURL},
+		{name: "localhost", url: "http://localhost:8080", wantErr: ErrPrivateAddress},
+		{name: "name of a private host", url: "http://intranet.example", wantErr: ErrPrivateAddress},
+		{name: "public ip", url: "http://93.184.216.34"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(context.Background(), tt.url)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			var rejected *Error
+			assert.ErrorAs(t, err, &rejected)
+		})
+	}
+}
+
+func TestPolicyPrivateNetworksAllowed(t *testing.T) {
+	policy, err := NewPolicy(config.Config{BaseURL: "http://localhost:8080"})
+	require.NoError(t, err)
+
+	assert.NoError(t, policy.Check(context.Background(), "http://10.0.0.5"))
+	assert.ErrorIs(t, policy.Check(context.Background(), "javascript:alert(1)"), ErrSchemeNotAllowed)
+}
+
+func TestPolicyMissingBlocklist(t *testing.T) {
+	_, err := NewPolicy(config.Config{URLBlocklistFile: filepath.Join(t.TempDir(), "missing")})
+	assert.Error(t, err)
+}
+
+// countingResolver counts lookups per host.
+type countingResolver struct {
+	resolver
+	mu      sync.Mutex
+	lookups map[string]int
+}
+
+func (r *countingResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mu.Lock()
+	r.lookups[host]++
+	r.mu.Unlock()
+	return r.resolver.LookupIPAddr(ctx, host)
+}
+
+func TestCheckAll(t *testing.T) {
+	policy, err := NewPolicy(config.Config{BaseURL: "https://sho.rt", BlockPrivateNetworks: true})
+	require.NoError(t, err)
+	counting := &countingResolver{
+		resolver: resolver{"good.example": "93.184.216.34", "intranet.example": "10.1.2.3"},
+		lookups:  map[string]int{},
+	}
+	policy.resolver = counting
+
+	urls := []string{
+		"https://good.example/1",
+		"https://good.example/2",
+		"https://intranet.example/1",
+		"https://good.example/3",
+		"https://intranet.example/2",
+	}
+	i, err := policy.CheckAll(context.Background(), urls)
+	assert.Equal(t, 2, i)
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+	assert.Equal(t, map[string]int{"good.example": 1, "intranet.example": 1}, counting.lookups)
+
+	i, err = policy.CheckAll(context.Background(), urls[:2])
+	assert.Equal(t, -1, i)
+	assert.NoError(t, err)
+}